@@ -4,8 +4,13 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -18,37 +23,47 @@ var installCmd = &cobra.Command{
 	Short: "Installs ZincObserve",
 	Long: `Installs ZincObserve.
 	`,
+	PreRunE: requireFlags("name", "k8s"),
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("Install Run called")
-		// name := cmd.Flags().Lookup("name").Value.String()
-		// namespace := cmd.Flags().Lookup("namespace").Value.String()
-		namespace := cmd.Flags().String("namespace", viper.GetString("metadata.namespace"), "namespace to install the helm chart")
-		k8s := cmd.Flags().String("k8s", viper.GetString("spec.k8s"), "k8s to install the helm chart")
-		// k8s := cmd.Flags().Lookup("k8s").Value.String()
-		install_minio := cmd.Flags().String("install_minio", viper.GetString("spec.install_minio"), "Specify if you want to install minio. Default is false.")
-		// install_minio := cmd.Flags().Lookup("install_minio").Value.String()
-		storage_provider := cmd.Flags().String("storage_provider", viper.GetString("spec.storage_provider"), "Valid values are s3, gcs, minio, swift.")
-		// storage_provider := cmd.Flags().Lookup("storage_provider").Value.String()
-		s3_access_key := cmd.Flags().String("s3_access_key", viper.GetString("spec.s3_access_key"), "S3 access key")
-		// s3_access_key := cmd.Flags().Lookup("s3_access_key").Value.String()
-		s3_secret_key := cmd.Flags().String("s3_secret_key", viper.GetString("spec.s3_secret_key"), "S3 secret key")
-		// s3_secret_key := cmd.Flags().Lookup("s3_secret_key").Value.String()
-		s3_server_url := cmd.Flags().String("s3_server_url", viper.GetString("spec.s3_server_url"), "S3 server url")
-		// s3_server_url := cmd.Flags().Lookup("s3_server_url").Value.String()
-		s3_bucket_name := cmd.Flags().String("s3_bucket_name", viper.GetString("spec.s3_bucket_name"), "S3 bucket name")
-		// s3_bucket_name := cmd.Flags().Lookup("s3_bucket_name").Value.String()
-		region := cmd.Flags().String("region", viper.GetString("spec.region"), "region to install the installation in.")
-		// region := cmd.Flags().Lookup("region").Value.String()
-		gcp_project_id := cmd.Flags().String("gcp_project_id", viper.GetString("spec.gcp_project_id"), "GCP Project ID to install the installation in.")
-		// gcp_project_id := cmd.Flags().Lookup("gcp_project_id").Value.String()
-
-		// get value for config
+		// flags are bound to the configuration keys, so values from the config file are used when a flag is not set
 		name := viper.GetString("metadata.name")
+		namespace := viper.GetString("metadata.namespace")
+		k8s := viper.GetString("spec.k8s")
+		install_minio := viper.GetString("spec.install_minio")
+		storage_provider := viper.GetString("spec.storage_provider")
+		s3_access_key := viper.GetString("spec.s3_access_key")
+		s3_secret_key := viper.GetString("spec.s3_secret_key")
+		s3_server_url := viper.GetString("spec.s3_server_url")
+		s3_bucket_name := viper.GetString("spec.s3_bucket_name")
+		region := viper.GetString("spec.region")
+		gcp_project_id := viper.GetString("spec.gcp_project_id")
+		bucket := viper.GetString("spec.bucket")
+		iam_role := viper.GetString("spec.iam_role")
+		create_oidc_provider := viper.GetBool("spec.create_oidc_provider")
+		aws_identity := viper.GetString("spec.aws_identity")
+		bucket_encryption := viper.GetString("spec.bucket_encryption")
+		kms_key := viper.GetString("spec.kms_key")
+		bucket_versioning := viper.GetBool("spec.bucket_versioning")
+		bucket_tags := viper.GetStringMapString("spec.bucket_tags")
+		if len(bucket_tags) == 0 {
+			// given as a flag, the tags are key=value pairs separated by commas
+			var err error
+			bucket_tags, err = parseBucketTags(viper.GetString("spec.bucket_tags"))
+			if err != nil {
+				fmt.Println("Error: ", err)
+				os.Exit(1)
+			}
+		}
+
+		if bucket != "" {
+			s3_bucket_name = bucket
+		}
 
 		fmt.Println("name is: ", name)
 
 		// convert install_minio to bool
-		install_minio_bool, err := strconv.ParseBool(*install_minio)
+		install_minio_bool, err := strconv.ParseBool(install_minio)
 		if err != nil {
 			install_minio_bool = false
 		}
@@ -59,40 +74,120 @@ var installCmd = &cobra.Command{
 		inputData := utils.SetupData{
 			Identifier:      release_identifer,
 			ReleaseName:     name,
-			Namespace:       *namespace,
-			Region:          *region,
-			K8s:             *k8s,
-			GCPProjectId:    *gcp_project_id,
-			S3AccessKey:     *s3_access_key,
-			S3SecretKey:     *s3_secret_key,
+			Namespace:       namespace,
+			Region:          region,
+			K8s:             k8s,
+			GCPProjectId:    gcp_project_id,
+			S3AccessKey:     s3_access_key,
+			S3SecretKey:     s3_secret_key,
 			InstallMinIO:    install_minio_bool,
-			StorageProvider: *storage_provider,
-			S3ServerURL:     *s3_server_url,
-			BucketName:      *s3_bucket_name,
+			StorageProvider: storage_provider,
+			S3ServerURL:     s3_server_url,
+			BucketName:      s3_bucket_name,
+			IamRole:         iam_role,
+
+			CreateOIDCProvider: create_oidc_provider,
+			AWSIdentity:        aws_identity,
+		}
+
+		// The settings of the bucket only apply to a bucket created by zctl on EKS
+		if k8s == "eks" && s3_bucket_name == "" {
+			inputData.BucketOptions, err = newBucketOptions(bucket_encryption, kms_key, bucket_versioning, bucket_tags)
+			if err != nil {
+				fmt.Println("Error: ", err)
+				os.Exit(1)
+			}
 		}
 
 		inputData, err = ValidateAndFix(inputData)
 		if err != nil {
 			fmt.Println("Error: ", err)
+			os.Exit(1)
+		}
+
+		dryRun, _ := cmd.Flags().GetBool("dry_run")
+		if dryRun {
+			output, _ := cmd.Flags().GetString("output")
+			if output != "text" && output != "json" {
+				fmt.Println("Error: invalid output format " + output + ". Valid values are: text, json")
+				os.Exit(1)
+			}
+
+			plan, err := utils.PlanSetup(inputData)
+			if err != nil {
+				fmt.Println("Error: ", err)
+				os.Exit(1)
+			}
+
+			if output == "json" {
+				b, err := json.MarshalIndent(plan, "", "  ")
+				if err != nil {
+					fmt.Println("Error: ", err)
+					os.Exit(1)
+				}
+				fmt.Println(string(b))
+			} else {
+				printPlan(plan)
+			}
 			return
 		}
 
-		setupData, err := utils.Setup(inputData)
+		rollbackOnFailure, _ := cmd.Flags().GetBool("rollback_on_failure")
+		_, err = utils.Setup(inputData, rollbackOnFailure)
 		if err != nil {
 			fmt.Println("Error: ", err)
-			panic(err)
+			os.Exit(1)
 		}
-
-		utils.CreateConfigMap(setupData)
 	},
 }
 
+// printPlan prints the install plan in a human readable form.
+func printPlan(plan utils.InstallPlan) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Release:\t%s\n", plan.ReleaseName)
+	fmt.Fprintf(w, "Namespace:\t%s\n", plan.Namespace)
+	fmt.Fprintf(w, "K8s:\t%s\n", plan.K8s)
+	fmt.Fprintf(w, "Kube context:\t%s\n", plan.KubeContext)
+	fmt.Fprintf(w, "Identifier:\t%s\n", plan.Identifier)
+	if plan.Resumed {
+		fmt.Fprintf(w, "Resumes:\tan incomplete install of the release\n")
+	}
+	if plan.ClusterName != "" {
+		fmt.Fprintf(w, "EKS cluster:\t%s\n", plan.ClusterName)
+		fmt.Fprintf(w, "AWS account:\t%s\n", plan.AccountID)
+		fmt.Fprintf(w, "Region:\t%s\n", plan.Region)
+	}
+	if plan.GCPProjectId != "" {
+		fmt.Fprintf(w, "GCP project:\t%s\n", plan.GCPProjectId)
+	}
+	fmt.Fprintf(w, "Chart version:\t%s\n", plan.ChartVersion)
+	fmt.Fprintf(w, "Image tag:\t%s\n", plan.ImageTag)
+
+	fmt.Fprintln(w, "\nACTION\tRESOURCE\tNAME")
+	for _, r := range plan.Resources {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Action, r.Kind, r.Name)
+	}
+	w.Flush()
+
+	for _, r := range plan.Resources {
+		names := make([]string, 0, len(r.Documents))
+		for name := range r.Documents {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Printf("\n%s %s, %s:\n%s\n", r.Kind, r.Name, name, r.Documents[name])
+		}
+	}
+
+	fmt.Printf("\nHelm manifests:\n%s", plan.Manifest)
+}
+
 func init() {
-	fmt.Println("init installCmd ")
 	rootCmd.AddCommand(installCmd)
 
-	fmt.Println("config file in installCmd: ", viper.ConfigFileUsed())
-
 	installCmd.Flags().String("namespace", viper.GetString("metadata.namespace"), "namespace to install the helm chart")
 	installCmd.Flags().String("region", viper.GetString("spec.region"), "region to install the installation in.")
 	installCmd.Flags().String("gcp_project_id", viper.GetString("spec.gcp_project_id"), "GCP Project ID to install the installation in.")
@@ -102,6 +197,17 @@ func init() {
 	installCmd.Flags().String("s3_server_url", viper.GetString("spec.s3_server_url"), "s3 compatible server url.")
 	installCmd.Flags().String("s3_access_key", viper.GetString("spec.s3_access_key"), "s3_access_key to use.")
 	installCmd.Flags().String("s3_secret_key", viper.GetString("spec.s3_secret_key"), "s3_secret_key to use.")
+	installCmd.Flags().String("bucket", viper.GetString("spec.bucket"), "Existing S3 bucket to use on EKS instead of creating one. It is never deleted by zctl.")
+	installCmd.Flags().String("iam_role", viper.GetString("spec.iam_role"), "Existing IAM role (name or ARN) to use on EKS instead of creating one. It is never deleted by zctl.")
+	installCmd.Flags().Bool("create_oidc_provider", viper.GetBool("spec.create_oidc_provider"), "Register the OIDC issuer of the EKS cluster as an IAM OIDC provider when it is missing. It is shared by the cluster and never deleted by zctl.")
+	installCmd.Flags().String("aws_identity", viper.GetString("spec.aws_identity"), "How the ZincObserve pods assume the IAM role on EKS. Valid values are irsa (IAM roles for service accounts, the default), pod-identity (EKS Pod Identity).")
+	installCmd.Flags().String("bucket_encryption", viper.GetString("spec.bucket_encryption"), "Default encryption of the S3 bucket created on EKS. Valid values are sse-s3, sse-kms. Defaults to sse-kms when --kms_key is set, sse-s3 otherwise.")
+	installCmd.Flags().String("kms_key", viper.GetString("spec.kms_key"), "ARN of the KMS key encrypting the S3 bucket created on EKS, or create to let zctl create a dedicated key. The IAM role created by zctl is granted the use of the key.")
+	installCmd.Flags().Bool("bucket_versioning", viper.GetBool("spec.bucket_versioning"), "Enable object versioning on the S3 bucket created on EKS.")
+	installCmd.Flags().String("bucket_tags", "", "Tags of the S3 bucket created on EKS, added to the standard tags, e.g. team=obs,env=prod.")
+	installCmd.Flags().Bool("dry_run", false, "Print the plan of the install (cloud resources, policies and rendered helm manifests) without creating anything.")
+	installCmd.Flags().StringP("output", "o", "text", "output format of the --dry_run plan. Valid values are text, json")
+	installCmd.Flags().Bool("rollback_on_failure", true, "Remove the created cloud resources when the install fails. When false, they are recorded for a later uninstall.")
 
 	// Bind the flags to the configuration keys
 	viper.BindPFlag("metadata.namespace", installCmd.Flags().Lookup("namespace"))
@@ -113,6 +219,14 @@ func init() {
 	viper.BindPFlag("spec.s3_server_url", installCmd.Flags().Lookup("s3_server_url"))
 	viper.BindPFlag("spec.s3_access_key", installCmd.Flags().Lookup("s3_access_key"))
 	viper.BindPFlag("spec.s3_secret_key", installCmd.Flags().Lookup("s3_secret_key"))
+	viper.BindPFlag("spec.bucket", installCmd.Flags().Lookup("bucket"))
+	viper.BindPFlag("spec.iam_role", installCmd.Flags().Lookup("iam_role"))
+	viper.BindPFlag("spec.create_oidc_provider", installCmd.Flags().Lookup("create_oidc_provider"))
+	viper.BindPFlag("spec.aws_identity", installCmd.Flags().Lookup("aws_identity"))
+	viper.BindPFlag("spec.bucket_encryption", installCmd.Flags().Lookup("bucket_encryption"))
+	viper.BindPFlag("spec.kms_key", installCmd.Flags().Lookup("kms_key"))
+	viper.BindPFlag("spec.bucket_versioning", installCmd.Flags().Lookup("bucket_versioning"))
+	viper.BindPFlag("spec.bucket_tags", installCmd.Flags().Lookup("bucket_tags"))
 
 	// Bind the flags to the command
 	installCmd.MarkFlagRequired("namespace")
@@ -120,12 +234,99 @@ func init() {
 	// installCmd.MarkFlagRequired("storage_provider")
 }
 
+// newBucketOptions validates the settings of the S3 bucket created on EKS. The encryption defaults to sse-kms when
+// a KMS key is given, sse-s3 otherwise. The KMS key is either the ARN of an existing key or create.
+func newBucketOptions(encryption, kmsKey string, versioning bool, tags map[string]string) (*utils.S3BucketOptions, error) {
+	if encryption == "" && kmsKey != "" {
+		encryption = utils.BucketEncryptionSSEKMS
+	} else if encryption == "" {
+		encryption = utils.BucketEncryptionSSES3
+	}
+
+	if encryption != utils.BucketEncryptionSSES3 && encryption != utils.BucketEncryptionSSEKMS {
+		return nil, fmt.Errorf("error: invalid value %s for --bucket_encryption. Valid values are: sse-s3, sse-kms", encryption)
+	}
+	if encryption == utils.BucketEncryptionSSEKMS && kmsKey == "" {
+		return nil, fmt.Errorf("error: --bucket_encryption=sse-kms requires --kms_key")
+	}
+	if encryption == utils.BucketEncryptionSSES3 && kmsKey != "" {
+		return nil, fmt.Errorf("error: --kms_key can only be used with --bucket_encryption=sse-kms")
+	}
+
+	// The role is granted the use of the key by its ARN, an alias or key ID cannot be used in its policy
+	if kmsKey == utils.KMSKeyCreate {
+		return &utils.S3BucketOptions{
+			Encryption:   encryption,
+			CreateKMSKey: true,
+			Versioning:   versioning,
+			Tags:         tags,
+		}, nil
+	}
+	if kmsKey != "" && (!strings.HasPrefix(kmsKey, "arn:") || !strings.Contains(kmsKey, ":kms:") || !strings.Contains(kmsKey, ":key/")) {
+		return nil, fmt.Errorf("error: invalid value %s for --kms_key. Valid values are the ARN of a KMS key (arn:aws:kms:<region>:<account>:key/<key-id>) or %s", kmsKey, utils.KMSKeyCreate)
+	}
+
+	return &utils.S3BucketOptions{
+		Encryption: encryption,
+		KMSKeyID:   kmsKey,
+		Versioning: versioning,
+		Tags:       tags,
+	}, nil
+}
+
+// parseBucketTags parses bucket tags given as key=value pairs separated by commas.
+func parseBucketTags(value string) (map[string]string, error) {
+	tags := map[string]string{}
+	if value == "" {
+		return tags, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		key, tagValue, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("error: invalid bucket tag %q, tags are key=value pairs separated by commas", pair)
+		}
+		tags[strings.TrimSpace(key)] = strings.TrimSpace(tagValue)
+	}
+
+	return tags, nil
+}
+
 // ValidateAndFix validates the input data and fixes it if possible
 func ValidateAndFix(setupData utils.SetupData) (utils.SetupData, error) {
 	if setupData.K8s == "eks" && setupData.Region == "" {
 		setupData.Region, _ = utils.GetDefaultAwsRegion()
 	}
 
+	// The bucket is created in the region of the installation, there is no fallback region
+	if setupData.K8s == "eks" && setupData.Region == "" {
+		return setupData, fmt.Errorf("error: no AWS region found, set --region or configure a default region (AWS_REGION or the AWS config file)")
+	}
+
+	if setupData.K8s != "eks" && setupData.IamRole != "" {
+		return setupData, fmt.Errorf("error: --iam_role can only be used with --k8s=eks")
+	}
+
+	if setupData.K8s != "eks" && setupData.CreateOIDCProvider {
+		return setupData, fmt.Errorf("error: --create_oidc_provider can only be used with --k8s=eks")
+	}
+
+	if setupData.K8s != "eks" && setupData.AWSIdentity != "" {
+		return setupData, fmt.Errorf("error: --aws_identity can only be used with --k8s=eks")
+	}
+
+	if setupData.K8s == "eks" && setupData.AWSIdentity == "" {
+		setupData.AWSIdentity = utils.AWSIdentityIRSA
+	}
+
+	if setupData.K8s == "eks" && setupData.AWSIdentity != utils.AWSIdentityIRSA && setupData.AWSIdentity != utils.AWSIdentityPodIdentity {
+		return setupData, fmt.Errorf("error: invalid value %s for --aws_identity. Valid values are: irsa, pod-identity", setupData.AWSIdentity)
+	}
+
+	if setupData.AWSIdentity == utils.AWSIdentityPodIdentity && setupData.CreateOIDCProvider {
+		return setupData, fmt.Errorf("error: --create_oidc_provider can only be used with --aws_identity=irsa")
+	}
+
 	if setupData.K8s == "gke" && setupData.GCPProjectId == "" {
 		return setupData, fmt.Errorf("error: You need to provide the --gcp_project_id if using GKE")
 	}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zinclabs/zctl/pkg/utils"
+)
+
+func TestParseBucketTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"empty", "", map[string]string{}, false},
+		{"single tag", "team=observability", map[string]string{"team": "observability"}, false},
+		{"several tags with spaces", "team = observability, env=prod", map[string]string{"team": "observability", "env": "prod"}, false},
+		{"empty value", "team=", map[string]string{"team": ""}, false},
+		{"value with equal sign", "query=a=b", map[string]string{"query": "a=b"}, false},
+		{"missing value", "team", nil, true},
+		{"empty key", "=observability", nil, true},
+		{"empty pair", "team=observability,", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBucketTags(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseBucketTags(%q) want an error", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBucketTags(%q) error = %v", tt.value, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBucketTags(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBucketOptions(t *testing.T) {
+	keyArn := "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	tags := map[string]string{"team": "observability"}
+
+	tests := []struct {
+		name       string
+		encryption string
+		kmsKey     string
+		versioning bool
+		want       *utils.S3BucketOptions
+		wantErr    bool
+	}{
+		{
+			name: "defaults to sse-s3",
+			want: &utils.S3BucketOptions{Encryption: utils.BucketEncryptionSSES3, Tags: tags},
+		},
+		{
+			name:       "versioning",
+			versioning: true,
+			want:       &utils.S3BucketOptions{Encryption: utils.BucketEncryptionSSES3, Versioning: true, Tags: tags},
+		},
+		{
+			name:   "KMS key implies sse-kms",
+			kmsKey: keyArn,
+			want:   &utils.S3BucketOptions{Encryption: utils.BucketEncryptionSSEKMS, KMSKeyID: keyArn, Tags: tags},
+		},
+		{
+			name:       "created KMS key",
+			encryption: utils.BucketEncryptionSSEKMS,
+			kmsKey:     utils.KMSKeyCreate,
+			want:       &utils.S3BucketOptions{Encryption: utils.BucketEncryptionSSEKMS, CreateKMSKey: true, Tags: tags},
+		},
+		{
+			name:       "sse-kms without key",
+			encryption: utils.BucketEncryptionSSEKMS,
+			wantErr:    true,
+		},
+		{
+			name:       "sse-s3 with key",
+			encryption: utils.BucketEncryptionSSES3,
+			kmsKey:     keyArn,
+			wantErr:    true,
+		},
+		{
+			name:       "unknown encryption",
+			encryption: "aes",
+			wantErr:    true,
+		},
+		{
+			name:    "key alias",
+			kmsKey:  "alias/zo1",
+			wantErr: true,
+		},
+		{
+			name:    "ARN of another service",
+			kmsKey:  "arn:aws:s3:::b1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newBucketOptions(tt.encryption, tt.kmsKey, tt.versioning, tags)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("newBucketOptions(%q, %q) want an error", tt.encryption, tt.kmsKey)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newBucketOptions(%q, %q) error = %v", tt.encryption, tt.kmsKey, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newBucketOptions(%q, %q) = %+v, want %+v", tt.encryption, tt.kmsKey, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlagNameAliases(t *testing.T) {
+	for _, name := range []string{"dry_run", "dry-run", "iam-role", "s3-bucket-name"} {
+		if installCmd.Flags().Lookup(name) == nil {
+			t.Errorf("install flag %q is not found", name)
+		}
+	}
+	for _, name := range []string{"purge_data", "purge-data"} {
+		if uninstallCmd.Flags().Lookup(name) == nil {
+			t.Errorf("uninstall flag %q is not found", name)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/zinclabs/zctl/pkg/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the ZincObserve installations managed by zctl in the cluster",
+	Long: `
+Lists the ZincObserve installations managed by zctl in all namespaces of the cluster, joining the
+setup state stored by zctl with the helm releases.
+
+Example:
+	zctl list
+	zctl list -o json
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		if output != "table" && output != "json" && output != "yaml" {
+			fmt.Println("Error: invalid output format " + output + ". Valid values are: table, json, yaml")
+			os.Exit(1)
+		}
+
+		installations, readErrs, err := utils.ListInstallations()
+		if err != nil {
+			fmt.Println("Error: ", err)
+			os.Exit(1)
+		}
+
+		switch output {
+		case "json":
+			b, err := json.MarshalIndent(installations, "", "  ")
+			if err != nil {
+				fmt.Println("Error: ", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(b))
+		case "yaml":
+			b, err := yaml.Marshal(installations)
+			if err != nil {
+				fmt.Println("Error: ", err)
+				os.Exit(1)
+			}
+			fmt.Print(string(b))
+		default:
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tNAMESPACE\tK8S\tSTORAGE\tBUCKET\tCHART\tAPP VERSION\tSTATUS")
+			for _, i := range installations {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					i.Name, i.Namespace, i.K8s, i.StorageProvider, i.BucketName, i.ChartVersion, i.AppVersion, i.Status)
+			}
+			w.Flush()
+		}
+
+		// The installations whose setup state cannot be read are reported after the others
+		for _, readErr := range readErrs {
+			fmt.Fprintln(os.Stderr, "Error: ", readErr)
+		}
+		if len(readErrs) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+
+	listCmd.Flags().StringP("output", "o", "table", "output format. Valid values are table, json, yaml")
+}
@@ -0,0 +1,82 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/zinclabs/zctl/pkg/utils"
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Rolls back a ZincObserve installation to a previous helm revision",
+	Long: `
+Rolls back a ZincObserve installation to a previous helm revision. The subtasks include:
+1. Read the setup data recorded for the target revision from the ConfigMap
+2. Roll back the helm release
+3. Restore the setup data of the target revision in the ConfigMap
+
+Example:
+	zctl --name=zo1 rollback --list
+	zctl --name=zo1 rollback              # roll back to the previous revision
+	zctl --name=zo1 rollback --revision=2
+	`,
+	PreRunE: requireFlags("name"),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := cmd.Flags().Lookup("name").Value.String()
+
+		namespace := cmd.Flags().Lookup("namespace").Value.String()
+		if namespace == "" {
+			namespace, _ = utils.GetCurrentNamespace()
+			fmt.Println("current namespace: ", namespace)
+		}
+
+		setupData, err := utils.ReadConfigMap(name, namespace)
+		if err != nil {
+			fmt.Println("error reading configmap for release: "+name+" in namespace: "+namespace+" : ", err)
+			os.Exit(1)
+		}
+
+		if list, _ := cmd.Flags().GetBool("list"); list {
+			history, err := utils.HelmHistory(setupData)
+			if err != nil {
+				fmt.Println("Error: ", err)
+				os.Exit(1)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "REVISION\tUPDATED\tSTATUS\tCHART\tAPP VERSION\tDESCRIPTION")
+			for _, rel := range history {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s-%s\t%s\t%s\n",
+					rel.Version, rel.Info.LastDeployed.Format("2006-01-02 15:04:05"), rel.Info.Status,
+					rel.Chart.Metadata.Name, rel.Chart.Metadata.Version, rel.Chart.Metadata.AppVersion, rel.Info.Description)
+			}
+			w.Flush()
+			return
+		}
+
+		revision, _ := cmd.Flags().GetInt("revision")
+		setupData, err = utils.RollbackTo(setupData, revision)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Rolled back %q, now at revision %d\n", setupData.ReleaseName, setupData.Revision)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+
+	rollbackCmd.Flags().String("namespace", viper.GetString("metadata.namespace"), "namespace of the installation")
+	rollbackCmd.Flags().Int("revision", 0, "helm revision to roll back to. Defaults to the previous revision")
+	rollbackCmd.Flags().Bool("list", false, "list the helm revisions of the installation instead of rolling back")
+}
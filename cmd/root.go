@@ -6,9 +6,12 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/zinclabs/zctl/pkg/utils"
 )
 
 // var cfgFile string
@@ -26,6 +29,14 @@ to quickly create a Cobra application.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		options := awsOptions()
+		if err := utils.ValidateAWSOptions(options); err != nil {
+			fmt.Println("Error: ", err)
+			os.Exit(1)
+		}
+		utils.SetAWSOptions(options)
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -38,7 +49,6 @@ func Execute() {
 }
 
 func init() {
-	fmt.Println("init rootCmd called")
 	cobra.OnInitialize(initConfig)
 
 	// Here you will define your flags and configuration settings.
@@ -51,13 +61,77 @@ func init() {
 
 	// Bind viper values to the root command flags
 	rootCmd.PersistentFlags().String("name", viper.GetString("metadata.name"), "name of the installation for underlying helm chart")
-	viper.BindPFlag("metadata.name", rootCmd.Flags().Lookup("name"))
-	rootCmd.MarkPersistentFlagRequired("name")
+	viper.BindPFlag("metadata.name", rootCmd.PersistentFlags().Lookup("name"))
 
 	rootCmd.PersistentFlags().String("k8s", viper.GetString("spec.k8s"), "k8s cluster type. eks, gke, plain")
-	viper.BindPFlag("spec.k8s", rootCmd.Flags().Lookup("k8s"))
-	rootCmd.MarkPersistentFlagRequired("k8s")
+	viper.BindPFlag("spec.k8s", rootCmd.PersistentFlags().Lookup("k8s"))
+
+	// Credentials of the AWS clients
+	rootCmd.PersistentFlags().String("aws_profile", viper.GetString("spec.aws_profile"), "profile of the AWS configuration and credentials files")
+	rootCmd.PersistentFlags().String("aws_role_arn", viper.GetString("spec.aws_role_arn"), "ARN of an IAM role to assume for every AWS operation")
+	rootCmd.PersistentFlags().String("aws_external_id", viper.GetString("spec.aws_external_id"), "external ID to assume the role given with --aws_role_arn")
+	rootCmd.PersistentFlags().String("aws_role_session_name", viper.GetString("spec.aws_role_session_name"), "session name of the role given with --aws_role_arn. Default zctl")
+	viper.BindPFlag("spec.aws_profile", rootCmd.PersistentFlags().Lookup("aws_profile"))
+	viper.BindPFlag("spec.aws_role_arn", rootCmd.PersistentFlags().Lookup("aws_role_arn"))
+	viper.BindPFlag("spec.aws_external_id", rootCmd.PersistentFlags().Lookup("aws_external_id"))
+	viper.BindPFlag("spec.aws_role_session_name", rootCmd.PersistentFlags().Lookup("aws_role_session_name"))
+
+	// Endpoints of the AWS services, e.g. to run against LocalStack
+	rootCmd.PersistentFlags().String("aws_endpoint_url", viper.GetString("spec.aws_endpoint_url"), "endpoint URL of every AWS service, e.g. http://localhost:4566 for LocalStack")
+	rootCmd.PersistentFlags().String("aws_s3_endpoint_url", viper.GetString("spec.aws_s3_endpoint_url"), "endpoint URL of S3, overriding --aws_endpoint_url")
+	rootCmd.PersistentFlags().String("aws_iam_endpoint_url", viper.GetString("spec.aws_iam_endpoint_url"), "endpoint URL of IAM, overriding --aws_endpoint_url")
+	rootCmd.PersistentFlags().String("aws_sts_endpoint_url", viper.GetString("spec.aws_sts_endpoint_url"), "endpoint URL of STS, overriding --aws_endpoint_url")
+	rootCmd.PersistentFlags().String("aws_eks_endpoint_url", viper.GetString("spec.aws_eks_endpoint_url"), "endpoint URL of EKS, overriding --aws_endpoint_url")
+	rootCmd.PersistentFlags().Bool("aws_s3_path_style", viper.GetBool("spec.aws_s3_path_style"), "use path-style addressing for S3. Always used with a custom S3 endpoint")
+	viper.BindPFlag("spec.aws_endpoint_url", rootCmd.PersistentFlags().Lookup("aws_endpoint_url"))
+	viper.BindPFlag("spec.aws_s3_endpoint_url", rootCmd.PersistentFlags().Lookup("aws_s3_endpoint_url"))
+	viper.BindPFlag("spec.aws_iam_endpoint_url", rootCmd.PersistentFlags().Lookup("aws_iam_endpoint_url"))
+	viper.BindPFlag("spec.aws_sts_endpoint_url", rootCmd.PersistentFlags().Lookup("aws_sts_endpoint_url"))
+	viper.BindPFlag("spec.aws_eks_endpoint_url", rootCmd.PersistentFlags().Lookup("aws_eks_endpoint_url"))
+	viper.BindPFlag("spec.aws_s3_path_style", rootCmd.PersistentFlags().Lookup("aws_s3_path_style"))
+
+	// Flags are named with underscores, the spellings with dashes are accepted as well, e.g. --dry-run for --dry_run
+	rootCmd.SetGlobalNormalizationFunc(normalizeFlagName)
+}
+
+// normalizeFlagName maps a flag name written with dashes to the name of the flag, which uses underscores.
+func normalizeFlagName(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	return pflag.NormalizedName(strings.ReplaceAll(name, "-", "_"))
+}
 
+// requireFlags returns a PreRunE function that fails when any of the given flags was not set.
+// It is used for the persistent root flags, which are only required by some of the commands.
+func requireFlags(names ...string) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		missing := []string{}
+		for _, name := range names {
+			if !cmd.Flags().Changed(name) {
+				missing = append(missing, name)
+			}
+		}
+
+		if len(missing) > 0 {
+			return fmt.Errorf("required flag(s) \"%s\" not set", strings.Join(missing, `", "`))
+		}
+
+		return nil
+	}
+}
+
+// awsOptions returns the options of the AWS clients given by the flags or the config file.
+func awsOptions() utils.AWSOptions {
+	return utils.AWSOptions{
+		Profile:         viper.GetString("spec.aws_profile"),
+		RoleArn:         viper.GetString("spec.aws_role_arn"),
+		ExternalID:      viper.GetString("spec.aws_external_id"),
+		RoleSessionName: viper.GetString("spec.aws_role_session_name"),
+		EndpointURL:     viper.GetString("spec.aws_endpoint_url"),
+		S3EndpointURL:   viper.GetString("spec.aws_s3_endpoint_url"),
+		IAMEndpointURL:  viper.GetString("spec.aws_iam_endpoint_url"),
+		STSEndpointURL:  viper.GetString("spec.aws_sts_endpoint_url"),
+		EKSEndpointURL:  viper.GetString("spec.aws_eks_endpoint_url"),
+		S3PathStyle:     viper.GetBool("spec.aws_s3_path_style"),
+	}
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -95,6 +169,6 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
@@ -0,0 +1,89 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/zinclabs/zctl/pkg/utils"
+)
+
+// stateCmd represents the state command
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Manages the setup state zctl stores for its installations",
+	Long: `
+Manages the setup state zctl stores for its installations in the zincobserve-setup-<name> ConfigMap and Secret.
+	`,
+}
+
+// stateMigrateCmd represents the state migrate command
+var stateMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Rewrites the setup state in the schema version of this zctl",
+	Long: `
+Rewrites the setup state in the schema version of this zctl. Setup state written by older zctl versions
+is upgraded automatically when it is read, this command persists the upgrade. Credentials still stored
+in the ConfigMap are moved to the Secret.
+
+Without --name, the setup state of every release in the namespace is migrated.
+
+Example:
+	zctl --name=zo1 --namespace=zo1 state migrate
+	zctl state migrate --all_namespaces
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name := cmd.Flags().Lookup("name").Value.String()
+
+		namespace := cmd.Flags().Lookup("namespace").Value.String()
+		if allNamespaces, _ := cmd.Flags().GetBool("all_namespaces"); allNamespaces {
+			namespace = ""
+		} else if namespace == "" {
+			namespace, _ = utils.GetCurrentNamespace()
+			fmt.Println("current namespace: ", namespace)
+		}
+
+		failed := false
+		releases := []utils.SetupData{{ReleaseName: name, Namespace: namespace}}
+		if name == "" {
+			var readErrs []error
+			var err error
+			releases, readErrs, err = utils.ListSetupData(namespace)
+			if err != nil {
+				fmt.Println("Error: ", err)
+				os.Exit(1)
+			}
+			for _, readErr := range readErrs {
+				fmt.Println("error migrating setup state: ", readErr)
+				failed = true
+			}
+		}
+
+		for _, release := range releases {
+			version, err := utils.MigrateState(release.ReleaseName, release.Namespace)
+			if err != nil {
+				fmt.Println("error migrating setup state for release: "+release.ReleaseName+" in namespace: "+release.Namespace+" : ", err)
+				failed = true
+				continue
+			}
+
+			fmt.Printf("Migrated setup state for release %s in namespace %s from %s to %s\n", release.ReleaseName, release.Namespace, version, utils.SetupDataAPIVersion)
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(stateCmd)
+	stateCmd.AddCommand(stateMigrateCmd)
+
+	stateMigrateCmd.Flags().String("namespace", viper.GetString("metadata.namespace"), "namespace of the installations")
+	stateMigrateCmd.Flags().Bool("all_namespaces", false, "migrate the installations in all namespaces")
+}
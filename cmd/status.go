@@ -0,0 +1,119 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/zinclabs/zctl/pkg/utils"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows a health report of a ZincObserve installation",
+	Long: `
+Shows a health report of a ZincObserve installation. The report includes:
+1. The helm release status and revision
+2. The readiness of each component (ingester, querier, router, compactor, alertmanager, ...)
+3. The binding of the PersistentVolumeClaims
+4. The existence of the recorded cloud resources (bucket, IAM role / GCP service account)
+
+The command exits with a non-zero code when anything is unhealthy.
+
+Example:
+	zctl --name=zo1 --namespace=zo1 status
+	zctl --name=zo1 --namespace=zo1 status -o json
+	`,
+	PreRunE: requireFlags("name"),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := cmd.Flags().Lookup("name").Value.String()
+
+		namespace := cmd.Flags().Lookup("namespace").Value.String()
+		if namespace == "" {
+			namespace, _ = utils.GetCurrentNamespace()
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		if output != "table" && output != "json" {
+			fmt.Println("Error: invalid output format " + output + ". Valid values are: table, json")
+			os.Exit(1)
+		}
+
+		setupData, err := utils.ReadSetupState(name, namespace)
+		if err != nil {
+			fmt.Println("error reading configmap for release: "+name+" in namespace: "+namespace+" : ", err)
+			os.Exit(1)
+		}
+
+		report, err := utils.GetInstallationStatus(setupData)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			os.Exit(1)
+		}
+
+		if output == "json" {
+			b, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				fmt.Println("Error: ", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(b))
+		} else {
+			printStatus(report)
+		}
+
+		if !report.Healthy {
+			os.Exit(1)
+		}
+	},
+}
+
+// printStatus prints the health report in a human readable form.
+func printStatus(report utils.InstallationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Release:\t%s\n", report.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", report.Namespace)
+	fmt.Fprintf(w, "Status:\t%s\n", report.ReleaseStatus)
+	fmt.Fprintf(w, "Revision:\t%d\n", report.Revision)
+
+	fmt.Fprintln(w, "\nCOMPONENT\tKIND\tNAME\tREADY\tHEALTHY")
+	for _, c := range report.Components {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%t\n", c.Component, c.Kind, c.Name, c.Ready, c.Desired, c.Healthy)
+	}
+
+	if len(report.Volumes) > 0 {
+		fmt.Fprintln(w, "\nVOLUME\tPHASE\tHEALTHY")
+		for _, v := range report.Volumes {
+			fmt.Fprintf(w, "%s\t%s\t%t\n", v.Name, v.Phase, v.Healthy)
+		}
+	}
+
+	if len(report.CloudResources) > 0 {
+		fmt.Fprintln(w, "\nRESOURCE\tNAME\tEXISTS\tHEALTHY")
+		for _, r := range report.CloudResources {
+			exists := fmt.Sprintf("%t", r.Exists)
+			if r.Error != "" {
+				exists = "error: " + r.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", r.Kind, r.Name, exists, r.Healthy)
+		}
+	}
+
+	fmt.Fprintf(w, "\nHealthy:\t%t\n", report.Healthy)
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().String("namespace", viper.GetString("metadata.namespace"), "namespace of the installation")
+	statusCmd.Flags().StringP("output", "o", "table", "output format. Valid values are table, json")
+}
@@ -0,0 +1,137 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/zinclabs/zctl/pkg/utils"
+)
+
+// templateCmd represents the template command
+var templateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Renders the values and manifests of ZincObserve without installing it",
+	Long: `
+Renders the effective helm values and the Kubernetes manifests of ZincObserve, e.g. for a GitOps pipeline.
+Neither the cluster nor the cloud provider is contacted and nothing is created. The subtasks include:
+1. Download the chart (or load it from --chart)
+2. Set up the chart values like install does
+3. Render the manifests (helm client-only dry run)
+
+Cloud-derived fields (bucket name, IAM role ARN, HMAC keys, ...) use the values passed as flags, or placeholders
+like <bucket-name> and <iam-role-arn> otherwise.
+
+The values and manifests are printed to stdout, the values commented out at the top of the stream.
+With --output_dir, they are written to values.yaml and manifests.yaml in the directory.
+
+Example:
+	zctl --name=zo1 --k8s=eks template --region=eu-west-1 --bucket=bucket1 --iam_role=arn:aws:iam::123456789012:role/role1
+	zctl --name=zo1 --k8s=eks template --chart=./charts/zincobserve --output_dir=./out
+	zctl --name=zo1 --k8s=plain template --install_minio --show=manifests
+	`,
+	PreRunE: requireFlags("name", "k8s"),
+	Run: func(cmd *cobra.Command, args []string) {
+		show, _ := cmd.Flags().GetString("show")
+		if show != "all" && show != "values" && show != "manifests" {
+			fmt.Println("Error: invalid value " + show + " for --show. Valid values are: all, values, manifests")
+			os.Exit(1)
+		}
+
+		setupData := utils.SetupData{
+			ReleaseName: cmd.Flags().Lookup("name").Value.String(),
+			K8s:         cmd.Flags().Lookup("k8s").Value.String(),
+		}
+		setupData.Namespace, _ = cmd.Flags().GetString("namespace")
+		setupData.Region, _ = cmd.Flags().GetString("region")
+		setupData.BucketRegion, _ = cmd.Flags().GetString("bucket_region")
+		setupData.ChartVersion, _ = cmd.Flags().GetString("chart_version")
+		setupData.ImageTag, _ = cmd.Flags().GetString("image_tag")
+		setupData.BucketName, _ = cmd.Flags().GetString("bucket")
+		setupData.IamRole, _ = cmd.Flags().GetString("iam_role")
+		setupData.AWSIdentity, _ = cmd.Flags().GetString("aws_identity")
+		setupData.S3ServerURL, _ = cmd.Flags().GetString("s3_server_url")
+		setupData.S3AccessKey, _ = cmd.Flags().GetString("s3_access_key")
+		setupData.S3SecretKey, _ = cmd.Flags().GetString("s3_secret_key")
+		setupData.InstallMinIO, _ = cmd.Flags().GetBool("install_minio")
+		setupData.Replicas.Ingester, _ = cmd.Flags().GetInt("ingester")
+		setupData.Replicas.Querier, _ = cmd.Flags().GetInt("querier")
+		setupData.Replicas.Router, _ = cmd.Flags().GetInt("router")
+		setupData.Replicas.Compactor, _ = cmd.Flags().GetInt("compactor")
+		setupData.Replicas.Alertmanager, _ = cmd.Flags().GetInt("alertmanager")
+		chartPath, _ := cmd.Flags().GetString("chart")
+
+		output, err := utils.RenderTemplate(setupData, chartPath)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			os.Exit(1)
+		}
+
+		outputDir, _ := cmd.Flags().GetString("output_dir")
+		if outputDir == "" {
+			if show == "all" {
+				fmt.Print(utils.CommentOut("Effective values:\n" + output.Values))
+			}
+			if show == "values" {
+				fmt.Print(output.Values)
+			}
+			if show != "values" {
+				fmt.Print(output.Manifests)
+			}
+			return
+		}
+
+		err = os.MkdirAll(outputDir, 0755)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			os.Exit(1)
+		}
+
+		files := map[string]string{}
+		if show != "manifests" {
+			files["values.yaml"] = output.Values
+		}
+		if show != "values" {
+			files["manifests.yaml"] = output.Manifests
+		}
+
+		for name, content := range files {
+			path := filepath.Join(outputDir, name)
+			err = os.WriteFile(path, []byte(content), 0644)
+			if err != nil {
+				fmt.Println("Error: ", err)
+				os.Exit(1)
+			}
+			fmt.Println("wrote " + path)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(templateCmd)
+
+	templateCmd.Flags().String("namespace", "default", "namespace the manifests are rendered for")
+	templateCmd.Flags().String("region", "", "AWS region of the installation on EKS. The default region of the chart is used when not set")
+	templateCmd.Flags().String("bucket_region", "", "region of the bucket given with --bucket on EKS, when it is located in another region than the installation")
+	templateCmd.Flags().String("chart", "", "path to a local chart directory or archive. The chart is downloaded when not set")
+	templateCmd.Flags().String("chart_version", "", "helm chart version to download")
+	templateCmd.Flags().String("image_tag", "", "ZincObserve image tag to deploy")
+	templateCmd.Flags().String("bucket", "", "bucket name. A placeholder is used when not set")
+	templateCmd.Flags().String("iam_role", "", "IAM role ARN for EKS. A placeholder is used when not set")
+	templateCmd.Flags().String("aws_identity", "irsa", "how the pods assume the IAM role on EKS: irsa (role-arn annotation) or pod-identity (service account named for the Pod Identity association)")
+	templateCmd.Flags().String("s3_server_url", "", "s3 compatible server url for plain k8s. A placeholder is used when not set")
+	templateCmd.Flags().String("s3_access_key", "", "s3 access key (HMAC access id on GKE). A placeholder is used when not set")
+	templateCmd.Flags().String("s3_secret_key", "", "s3 secret key (HMAC secret on GKE). A placeholder is used when not set")
+	templateCmd.Flags().Bool("install_minio", false, "render the chart with MinIO enabled (plain k8s)")
+	templateCmd.Flags().Int("ingester", 0, "number of ingester replicas")
+	templateCmd.Flags().Int("querier", 0, "number of querier replicas")
+	templateCmd.Flags().Int("router", 0, "number of router replicas")
+	templateCmd.Flags().Int("compactor", 0, "number of compactor replicas")
+	templateCmd.Flags().Int("alertmanager", 0, "number of alertmanager replicas")
+	templateCmd.Flags().String("output_dir", "", "directory to write values.yaml and manifests.yaml to, instead of stdout")
+	templateCmd.Flags().String("show", "all", "what to render. Valid values are all, values, manifests")
+}
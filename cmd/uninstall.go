@@ -4,7 +4,11 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -22,7 +26,25 @@ Uninstalls a ZincObserve installation in the EKS cluster. The subtasks include:
 3. Uninstall the S3 bucket
 4. Uninstall the helm release
 
+Every step runs unless it depends on a step that failed, and resources that are already gone count as removed.
+The result of every step is printed at the end, and the command exits with a non-zero status when a step failed.
+The setup ConfigMap is kept until every step succeeded, so that the uninstall can simply be run again.
+
+The helm release is uninstalled from the namespace and kube context, and with the helm storage driver, recorded at
+install time. The uninstall is refused when the current kube context points at a different cluster than the one the
+release was installed on.
+
+The bucket holding the data is kept. With --purge_data, the bucket (S3, GCS or external MinIO) is emptied,
+including every object version, and deleted, and the PersistentVolumeClaims of the release (ingester, etcd,
+MinIO) are deleted. Adopted buckets are never deleted. The release name must be typed to confirm the purge,
+or passed with --confirm.
+
+Example:
+	zctl --name=zo1 uninstall
+	zctl --name=zo1 uninstall --purge_data
+	zctl --name=zo1 uninstall --purge_data --confirm=zo1
 	`,
+	PreRunE: requireFlags("name"),
 	Run: func(cmd *cobra.Command, args []string) {
 		name := cmd.Flags().Lookup("name").Value.String()
 
@@ -36,14 +58,59 @@ Uninstalls a ZincObserve installation in the EKS cluster. The subtasks include:
 		if region == "" {
 			region, _ = utils.GetDefaultAwsRegion()
 		}
-		utils.Teardown(name, namespace, region)
+		purgeData, _ := cmd.Flags().GetBool("purge_data")
+		if purgeData && !confirmPurge(cmd, name) {
+			fmt.Println("Error: the release name was not confirmed, nothing was uninstalled")
+			os.Exit(1)
+		}
+
+		report, err := utils.Teardown(name, namespace, region, purgeData)
+		printTeardownReport(report)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			os.Exit(1)
+		}
 	},
 }
 
+// printTeardownReport prints the result of every step of the uninstall.
+func printTeardownReport(report utils.TeardownReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "\nRelease:\t%s\n", report.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", report.Namespace)
+
+	fmt.Fprintln(w, "\nSTEP\tSTATUS\tDETAIL")
+	for _, step := range report.Steps {
+		detail := step.Reason
+		if step.Error != "" {
+			detail = step.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", step.Step, step.Status, detail)
+	}
+
+	w.Flush()
+}
+
+// confirmPurge asks the user to type the release name before its data is purged. The name can also be given with --confirm.
+func confirmPurge(cmd *cobra.Command, name string) bool {
+	confirm, _ := cmd.Flags().GetString("confirm")
+	if confirm == "" {
+		fmt.Printf("All the data of release %s (bucket and persistent volumes) will be deleted permanently.\n", name)
+		fmt.Printf("Type the release name to confirm: ")
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		confirm = strings.TrimSpace(line)
+	}
+
+	return confirm == name
+}
+
 func init() {
 	rootCmd.AddCommand(uninstallCmd)
 
 	// Bind viper values to the uninstall command flags
 	uninstallCmd.Flags().String("namespace", viper.GetString("namespace"), "namespace to install the helm chart")
 	uninstallCmd.Flags().String("region", viper.GetString("region"), "region to delete the installation from ")
+	uninstallCmd.Flags().Bool("purge_data", false, "empty and delete the bucket and delete the persistent volume claims of the release")
+	uninstallCmd.Flags().String("confirm", "", "release name confirming --purge_data without a prompt")
 }
@@ -1,40 +1,151 @@
 /*
 Copyright © 2023 NAME HERE <EMAIL ADDRESS>
-
 */
 package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/zinclabs/zctl/pkg/utils"
 )
 
 // updateCmd represents the update command
 var updateCmd = &cobra.Command{
 	Use:   "update",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Short: "Updates an existing ZincObserve installation in place",
+	Long: `
+Updates an existing ZincObserve installation in place using a helm upgrade. The subtasks include:
+1. Read the setup data from the ConfigMap
+2. Apply the requested image tag, chart version and replica counts
+3. Upgrade the helm release, keeping the bucket, IAM role and HMAC keys wiring
+4. Update the ConfigMap
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+Example:
+	zctl --name=zo1 --image_tag=v0.3.2 update
+	`,
+	PreRunE: requireFlags("name"),
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("update called")
+		name := cmd.Flags().Lookup("name").Value.String()
+
+		namespace := cmd.Flags().Lookup("namespace").Value.String()
+		if namespace == "" {
+			namespace, _ = utils.GetCurrentNamespace()
+			fmt.Println("current namespace: ", namespace)
+		}
+
+		setupData, err := utils.ReadConfigMap(name, namespace)
+		if err != nil {
+			fmt.Println("error reading configmap for release: "+name+" in namespace: "+namespace+" : ", err)
+			os.Exit(1)
+		}
+
+		if imageTag, _ := cmd.Flags().GetString("image_tag"); imageTag != "" {
+			setupData.ImageTag = imageTag
+		}
+		if chartVersion, _ := cmd.Flags().GetString("chart_version"); chartVersion != "" {
+			setupData.ChartVersion = chartVersion
+		}
+		if replicas, _ := cmd.Flags().GetInt("ingester"); replicas > 0 {
+			setupData.Replicas.Ingester = replicas
+		}
+		if replicas, _ := cmd.Flags().GetInt("querier"); replicas > 0 {
+			setupData.Replicas.Querier = replicas
+		}
+		if replicas, _ := cmd.Flags().GetInt("router"); replicas > 0 {
+			setupData.Replicas.Router = replicas
+		}
+		if replicas, _ := cmd.Flags().GetInt("compactor"); replicas > 0 {
+			setupData.Replicas.Compactor = replicas
+		}
+		if replicas, _ := cmd.Flags().GetInt("alertmanager"); replicas > 0 {
+			setupData.Replicas.Alertmanager = replicas
+		}
+
+		if bucketOptionsChanged(cmd) {
+			if setupData.K8s != "eks" || setupData.AdoptedBucket {
+				fmt.Println("Error: the bucket settings can only be changed for an S3 bucket created by zctl on EKS")
+				os.Exit(1)
+			}
+
+			options := utils.S3BucketOptions{}
+			if setupData.BucketOptions != nil {
+				options = *setupData.BucketOptions
+			}
+			// The data of the bucket stays encrypted with the key created by zctl, which is deleted with it
+			createdKey := utils.CreatedKMSKey(setupData)
+			if createdKey != "" && (cmd.Flags().Changed("bucket_encryption") || cmd.Flags().Changed("kms_key")) {
+				fmt.Printf("Error: the bucket is encrypted with KMS key %s created by zctl, its encryption cannot be changed\n", createdKey)
+				os.Exit(1)
+			}
+			if kmsKey, _ := cmd.Flags().GetString("kms_key"); kmsKey == utils.KMSKeyCreate {
+				fmt.Printf("Error: --kms_key=%s is only supported by install, give the ARN of an existing KMS key\n", utils.KMSKeyCreate)
+				os.Exit(1)
+			}
+			if cmd.Flags().Changed("bucket_encryption") {
+				options.Encryption, _ = cmd.Flags().GetString("bucket_encryption")
+				options.KMSKeyID = ""
+			}
+			if cmd.Flags().Changed("kms_key") {
+				options.KMSKeyID, _ = cmd.Flags().GetString("kms_key")
+				if !cmd.Flags().Changed("bucket_encryption") {
+					options.Encryption = ""
+				}
+			}
+			if cmd.Flags().Changed("bucket_versioning") {
+				options.Versioning, _ = cmd.Flags().GetBool("bucket_versioning")
+			}
+			if cmd.Flags().Changed("bucket_tags") {
+				value, _ := cmd.Flags().GetString("bucket_tags")
+				options.Tags, err = parseBucketTags(value)
+				if err != nil {
+					fmt.Println("Error: ", err)
+					os.Exit(1)
+				}
+			}
+
+			setupData.BucketOptions, err = newBucketOptions(options.Encryption, options.KMSKeyID, options.Versioning, options.Tags)
+			if err != nil {
+				fmt.Println("Error: ", err)
+				os.Exit(1)
+			}
+			setupData.BucketOptions.CreateKMSKey = createdKey != ""
+		}
+
+		_, err = utils.Update(setupData)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			os.Exit(1)
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(updateCmd)
 
-	// Here you will define your flags and configuration settings.
+	updateCmd.Flags().String("namespace", viper.GetString("metadata.namespace"), "namespace of the installation")
+	updateCmd.Flags().String("image_tag", "", "ZincObserve image tag to deploy")
+	updateCmd.Flags().String("chart_version", "", "helm chart version to upgrade to")
+	updateCmd.Flags().Int("ingester", 0, "number of ingester replicas")
+	updateCmd.Flags().Int("querier", 0, "number of querier replicas")
+	updateCmd.Flags().Int("router", 0, "number of router replicas")
+	updateCmd.Flags().Int("compactor", 0, "number of compactor replicas")
+	updateCmd.Flags().Int("alertmanager", 0, "number of alertmanager replicas")
+	updateCmd.Flags().String("bucket_encryption", "", "default encryption of the S3 bucket created on EKS. Valid values are sse-s3, sse-kms")
+	updateCmd.Flags().String("kms_key", "", "ARN of the KMS key encrypting the S3 bucket created on EKS. The IAM role created by zctl is granted the use of the key")
+	updateCmd.Flags().Bool("bucket_versioning", false, "enable object versioning on the S3 bucket created on EKS")
+	updateCmd.Flags().String("bucket_tags", "", "tags of the S3 bucket created on EKS, added to the standard tags, e.g. team=obs,env=prod. Replaces the recorded tags")
+}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// updateCmd.PersistentFlags().String("foo", "", "A help for foo")
+// bucketOptionsChanged reports whether a setting of the S3 bucket is changed by the update.
+func bucketOptionsChanged(cmd *cobra.Command) bool {
+	for _, flag := range []string{"bucket_encryption", "kms_key", "bucket_versioning", "bucket_tags"} {
+		if cmd.Flags().Changed(flag) {
+			return true
+		}
+	}
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// updateCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	return false
 }
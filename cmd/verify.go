@@ -0,0 +1,117 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/zinclabs/zctl/pkg/utils"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Detects drift between the recorded setup of a ZincObserve installation and the cloud resources",
+	Long: `
+Compares the setup data recorded by zctl with the cloud resources and the helm release, and reports each drift:
+1. The bucket exists
+2. EKS: the IAM role exists, and its trust policy and zo-s3 inline policy match the ones created by zctl
+3. GKE: the service account exists, is granted roles/storage.objectAdmin on the bucket, and its HMAC key is active
+4. The helm values still reference the bucket, the IAM role and the credentials
+
+With --fix, every drift is repaired: missing resources are created again, policies and bindings are restored,
+a missing HMAC key is replaced, and the helm release is upgraded with the recorded values.
+Adopted buckets and IAM roles are checked but never modified.
+
+The command exits with a non-zero code when a drift remains.
+
+Example:
+	zctl --name=zo1 --namespace=zo1 verify
+	zctl --name=zo1 --namespace=zo1 verify --fix
+	`,
+	PreRunE: requireFlags("name"),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := cmd.Flags().Lookup("name").Value.String()
+
+		namespace := cmd.Flags().Lookup("namespace").Value.String()
+		if namespace == "" {
+			namespace, _ = utils.GetCurrentNamespace()
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		if output != "table" && output != "json" {
+			fmt.Println("Error: invalid output format " + output + ". Valid values are: table, json")
+			os.Exit(1)
+		}
+		fix, _ := cmd.Flags().GetBool("fix")
+
+		setupData, err := utils.ReadConfigMap(name, namespace)
+		if err != nil {
+			fmt.Println("error reading configmap for release: "+name+" in namespace: "+namespace+" : ", err)
+			os.Exit(1)
+		}
+
+		report, err := utils.VerifyInstallation(setupData, fix)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			os.Exit(1)
+		}
+
+		if output == "json" {
+			b, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				fmt.Println("Error: ", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(b))
+		} else {
+			printVerifyReport(report)
+		}
+
+		if report.Drifted {
+			os.Exit(1)
+		}
+	},
+}
+
+// printVerifyReport prints the drift report in a human readable form.
+func printVerifyReport(report utils.VerifyReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Release:\t%s\n", report.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", report.Namespace)
+
+	fmt.Fprintln(w, "\nRESOURCE\tNAME\tCHECK\tRESULT")
+	for _, c := range report.Checks {
+		result := "ok"
+		if c.Drift != "" {
+			result = "drift: " + c.Drift
+			if c.Fixed {
+				result += " (fixed)"
+			}
+		}
+		if c.Error != "" && c.Drift == "" {
+			result = "error: " + c.Error
+		} else if c.Error != "" {
+			result += " error: " + c.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Kind, c.Name, c.Check, result)
+	}
+
+	fmt.Fprintf(w, "\nDrifted:\t%t\n", report.Drifted)
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().String("namespace", viper.GetString("metadata.namespace"), "namespace of the installation")
+	verifyCmd.Flags().Bool("fix", false, "repair the drift found")
+	verifyCmd.Flags().StringP("output", "o", "table", "output format. Valid values are table, json")
+}
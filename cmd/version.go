@@ -1,40 +1,98 @@
 /*
 Copyright © 2023 NAME HERE <EMAIL ADDRESS>
-
 */
 package cmd
 
 import (
 	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/zinclabs/zctl/pkg/utils"
+)
+
+// Build information. These are set at build time using
+//
+//	go build -ldflags "-X github.com/zinclabs/zctl/cmd.Version=v0.1.0 -X github.com/zinclabs/zctl/cmd.GitCommit=$(git rev-parse HEAD) -X github.com/zinclabs/zctl/cmd.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	GitCommit = ""
+	BuildDate = ""
 )
 
 // versionCmd represents the version command
 var versionCmd = &cobra.Command{
 	Use:   "version",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Short: "Prints the zctl version and the versions of a deployed ZincObserve release",
+	Long: `
+Prints the zctl build information and the chart, app and image versions zctl installs by default.
+When --name is given, the versions of the deployed release are printed as well, with a warning
+when the deployed chart version differs from the one this zctl installs.
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+Example:
+	zctl version
+	zctl --name=zo1 --namespace=zo1 version
+	`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("version called")
+		commit, date := GitCommit, BuildDate
+		if info, ok := debug.ReadBuildInfo(); ok {
+			// Fall back to the VCS information embedded by the go toolchain
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" && commit == "" {
+					commit = setting.Value
+				}
+				if setting.Key == "vcs.time" && date == "" {
+					date = setting.Value
+				}
+			}
+		}
+
+		fmt.Println("Client:")
+		fmt.Println("  Version:       ", Version)
+		fmt.Println("  Git commit:    ", commit)
+		fmt.Println("  Build date:    ", date)
+		fmt.Println("  Go version:    ", runtime.Version())
+		fmt.Println("  Chart version: ", utils.DefaultChartVersion)
+		fmt.Println("  App version:   ", utils.DefaultAppVersion)
+		fmt.Println("  Image tag:     ", utils.DefaultImageTag)
+
+		name := cmd.Flags().Lookup("name").Value.String()
+		if name == "" {
+			return
+		}
+
+		namespace := cmd.Flags().Lookup("namespace").Value.String()
+		if namespace == "" {
+			namespace, _ = utils.GetCurrentNamespace()
+		}
+
+		deployed, err := utils.DeployedReleaseVersions(name, namespace)
+		if err != nil {
+			fmt.Println("error getting release: "+name+" in namespace: "+namespace+" : ", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Server:")
+		fmt.Println("  Release:       ", name)
+		fmt.Println("  Namespace:     ", namespace)
+		fmt.Println("  Revision:      ", deployed.Revision)
+		fmt.Println("  Status:        ", deployed.Status)
+		fmt.Println("  Chart version: ", deployed.ChartVersion)
+		fmt.Println("  App version:   ", deployed.AppVersion)
+		fmt.Println("  Image tag:     ", deployed.ImageTag)
+
+		if deployed.ChartVersion != utils.DefaultChartVersion {
+			fmt.Printf("\nWarning: release %q is deployed with chart version %s, while this zctl installs chart version %s. Run 'zctl --name=%s update --chart_version=%s' to align them.\n",
+				name, deployed.ChartVersion, utils.DefaultChartVersion, name, utils.DefaultChartVersion)
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(versionCmd)
 
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// versionCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// versionCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	versionCmd.Flags().String("namespace", viper.GetString("metadata.namespace"), "namespace of the installation")
 }
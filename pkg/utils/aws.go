@@ -5,9 +5,10 @@ import "fmt"
 // SetupAWS sets up the necessary AWS resources for a given release.
 // It returns the name of the S3 bucket and the IAM role ARN that were created.
 // If an error occurs, it returns an empty string for both values and the error itself.
-func SetupAWS(setupData SetupData) (string, string, string, error) {
+// Every created resource is recorded in the journal as soon as it is created.
+func SetupAWS(setupData SetupData, journal *Journal) (string, string, string, error) {
 	// First, get the name of the current EKS cluster.
-	clusterName, err := GetCurrentEKSClusterName()
+	clusterName, err := GetCurrentEKSClusterName(setupData.Region)
 	if err != nil {
 		fmt.Println(err)
 		return "", "", "", err
@@ -16,11 +17,11 @@ func SetupAWS(setupData SetupData) (string, string, string, error) {
 	setupData.ClusterName = clusterName
 
 	// Set up the necessary AWS resources (S3 bucket and IAM role) for the release.
-	bucketName, roleName, err := SetupAWSBase(setupData)
+	bucketName, roleArn, err := SetupAWSBase(setupData, journal)
 	if err != nil {
 		return "", "", "", err
 	}
 
 	// Return the names of the created resources.
-	return bucketName, roleName, clusterName, nil
+	return bucketName, roleArn, clusterName, nil
 }
@@ -1,78 +1,185 @@
 package utils
 
-import (
-	"context"
-	"fmt"
-
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/eks/types"
-)
+import "fmt"
 
 // SetupAWSBase creates an S3 bucket, IAM role and inline policy for the role. It returns the ARN of the role.
+// The KMS key encrypting the bucket is created first when the bucket options ask zctl to create it.
+// The bucket and the role are recorded in the journal as soon as they are created, and are not created again
+// when the journal of a resumed install already holds them. An existing bucket or role is adopted instead of created
+// when the setup data marks it as adopted; it is verified to be usable by the installation and never recorded in the journal.
+// The region of an adopted bucket is expected in the setup data, see S3BucketRegion.
+// In pod-identity mode, the role is trusted by EKS Pod Identity and associated with the service account of the chart.
 // func SetupAWSBase(releaseIdentifer, clusterName, releaseName, region string) (string, string, error) {
-func SetupAWSBase(setupData SetupData) (string, string, error) {
-	exists, err := HasOIDCProvider(setupData.ClusterName, setupData.Region)
+func SetupAWSBase(setupData SetupData, journal *Journal) (string, string, error) {
+	awsAccountId, err := GetAWSAccountID()
 	if err != nil {
-		fmt.Println("error: ", err)
 		return "", "", err
 	}
 
-	clusterDetails := &types.Cluster{}
-	if exists {
-		// Get EKS cluster details
-		clusterDetails, err = GetEKSClusterDetails(setupData.ClusterName)
+	// In irsa mode, the IAM role is assumed through the IAM OIDC provider of the cluster issuer.
+	// In pod-identity mode, it is assumed by EKS Pod Identity for the service account of the chart.
+	issuer := ""
+	trustPolicy := GetPodIdentityTrustPolicyDocument()
+	if setupData.AWSIdentity != AWSIdentityPodIdentity {
+		issuer, err = EnsureOIDCProvider(setupData.ClusterName, setupData.Region, setupData.CreateOIDCProvider)
 		if err != nil {
+			fmt.Println("error: ", err)
 			return "", "", err
 		}
+		trustPolicy = GetIAMTrustPolicyDocument(awsAccountId, issuer)
 	}
 
-	// capture items that we need in next steps
-	issuer := *clusterDetails.Identity.Oidc.Issuer
-	issuerId := issuer[len(issuer)-32:]
-	awsAccountId, err := GetAWSAccountID()
-	if err != nil {
-		return "", "", err
-	}
-
-	// create an s3 bucket
+	// create an s3 bucket, unless an existing bucket is adopted or a resumed install already created it.
+	// Adopted resources are never recorded in the journal, so that a rollback never deletes them.
 	bucketName := "zinc-observe-" + setupData.Identifier + "-" + setupData.ClusterName + "-" + setupData.ReleaseName
-	err = CreateS3Bucket(bucketName, setupData.Region)
-	if err != nil {
-		return "", "", err
+	if setupData.AdoptedBucket {
+		bucketName = setupData.BucketName
+		exists, err := S3BucketExists(bucketName, S3BucketRegion(setupData))
+		if err != nil {
+			return "", "", err
+		}
+		if !exists {
+			return "", "", fmt.Errorf("bucket %s does not exist", bucketName)
+		}
+		if S3BucketRegion(setupData) != setupData.Region {
+			fmt.Printf("Bucket %s is located in %s, not in the region of the installation %s\n", bucketName, S3BucketRegion(setupData), setupData.Region)
+		}
+		fmt.Println("Using existing bucket: ", bucketName)
+	} else {
+		// create the KMS key encrypting the bucket, unless a resumed install already created it
+		if setupData.BucketOptions != nil && setupData.BucketOptions.CreateKMSKey {
+			options := *setupData.BucketOptions
+			if entry, ok := journal.Find(ResourceKMSKey); ok {
+				fmt.Println("KMS key already created: ", entry.Name)
+				options.KMSKeyID = entry.Name
+			} else {
+				options.KMSKeyID, err = CreateKMSKey(KMSKeyAlias(setupData), setupData.Region, GetKMSKeyPolicyDocument(awsAccountId), S3BucketTags(setupData))
+				if err != nil {
+					return "", "", err
+				}
+				setupData.BucketOptions = &options
+				err = journal.Record(setupData, ResourceKMSKey, options.KMSKeyID, setupData.Region)
+				if err != nil {
+					return "", "", err
+				}
+			}
+			setupData.BucketOptions = &options
+		}
+
+		if _, ok := journal.Find(ResourceS3Bucket); ok {
+			fmt.Println("Bucket already created: ", bucketName)
+		} else {
+			err = CreateS3Bucket(bucketName, setupData.Region)
+			if err != nil {
+				return "", "", err
+			}
+			setupData.BucketName = bucketName
+			err = journal.Record(setupData, ResourceS3Bucket, bucketName, setupData.Region)
+			if err != nil {
+				return "", "", err
+			}
+		}
+
+		// The settings are applied again when a resumed install already created the bucket
+		if setupData.BucketOptions != nil {
+			err = ApplyS3BucketOptions(bucketName, setupData.Region, *setupData.BucketOptions, S3BucketTags(setupData))
+			if err != nil {
+				return "", "", err
+			}
+		}
 	}
 
-	// create an IAM role
+	// create an IAM role, unless an existing role is adopted or a resumed install already created it.
+	// The ARN is the one reported by IAM, which holds the path and partition of the role.
 	roleName := "zinc-observe-" + setupData.Identifier + "-" + setupData.ClusterName + "-" + setupData.ReleaseName
-	_, err = CreateIAMRole(awsAccountId, setupData.Region, issuerId, roleName, "zo-s3", setupData.ClusterName, setupData.ReleaseName, bucketName)
-	if err != nil {
-		return "", "", err
+	roleArn := ""
+	if setupData.AdoptedIamRole {
+		err = VerifyAdoptedIAMRole(setupData.IamRole, setupData.Region, setupData.AWSIdentity, awsAccountId, issuer, bucketName, BucketKMSKey(setupData))
+		if err != nil {
+			return "", "", err
+		}
+		roleArn, err = GetIAMRoleArn(setupData.IamRole, setupData.Region) // the role can be given by name or ARN
+		if err != nil {
+			return "", "", err
+		}
+		fmt.Println("Using existing IAM role: ", roleArn)
+	} else if entry, ok := journal.Find(ResourceIAMRole); ok {
+		fmt.Println("IAM role already created: ", entry.Name)
+		roleArn = entry.Name
+	} else {
+		roleArn, err = CreateIAMRoleWithTrustPolicy(trustPolicy, roleName, setupData.Region, "zo-s3", bucketName, BucketKMSKey(setupData))
+		if err != nil {
+			return "", "", err
+		}
+		setupData.IamRole = roleArn
+		err = journal.Record(setupData, ResourceIAMRole, roleArn, "")
+		if err != nil {
+			return "", "", err
+		}
 	}
 
-	return bucketName, roleName, nil
+	// associate the role with the service account of the chart, unless a resumed install already did
+	if setupData.AWSIdentity == AWSIdentityPodIdentity {
+		if entry, ok := journal.Find(ResourcePodIdentityAssociation); ok {
+			fmt.Println("Pod Identity association already created: ", entry.Name)
+		} else {
+			associationArn, err := CreatePodIdentityAssociation(setupData.ClusterName, setupData.Region, setupData.Namespace, PodIdentityServiceAccountName(setupData), roleArn)
+			if err != nil {
+				return "", "", err
+			}
+			setupData.PodIdentityAssociation = associationArn
+			err = journal.Record(setupData, ResourcePodIdentityAssociation, associationArn, "")
+			if err != nil {
+				return "", "", err
+			}
+		}
+	}
+
+	return bucketName, roleArn, nil
 }
 
 // TearDownAWS tears down the AWS resources associated with a given release.
-// It deletes the S3 bucket and the IAM role and policy.
-// If an error occurs, it panics with the error message.
+// It deletes the S3 bucket, the Pod Identity association and the IAM role and policy. Adopted resources are never deleted,
+// and resources that are already gone count as deleted.
 func TearDownAWS(setupData SetupData, region string) error {
 	// err := DeleteS3Bucket(setupData.BucketName, region) // We do not want to delete the bucket
 	// if err != nil {
 	// 	return err
 	// }
 
-	err := DeleteIAMRoleWithPolicies(setupData.IamRole)
-	if err != nil {
-		return err
+	// The association is created by zctl, also for an adopted role. The role is deleted even when it is not.
+	var associationErr error
+	if setupData.PodIdentityAssociation != "" {
+		associationErr = DeletePodIdentityAssociation(setupData.PodIdentityAssociation)
+	}
+
+	if setupData.AdoptedIamRole {
+		fmt.Println("Keeping adopted IAM role: ", setupData.IamRole)
+		return associationErr
+	}
+
+	// A role that does not exist counts as deleted
+	exists, err := IAMRoleExists(setupData.IamRole, setupData.Region)
+	if err == nil && exists {
+		err = DeleteIAMRoleWithPolicies(setupData.IamRole, setupData.Region)
+	} else if err == nil {
+		fmt.Println("IAM role already deleted: ", setupData.IamRole)
+	}
+
+	if associationErr != nil && err != nil {
+		return fmt.Errorf("%v; %v", associationErr, err)
+	} else if associationErr != nil {
+		return associationErr
 	}
 
-	return nil
+	return err
 }
 
 // GetDefaultAwsRegion retrieves the default region for the AWS account.
 // It returns the region string, or an error if one occurs.
 func GetDefaultAwsRegion() (string, error) {
 	// Load the AWS configuration.
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	cfg, err := loadAWSConfig("")
 	if err != nil {
 		return "", err
 	}
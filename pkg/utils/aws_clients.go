@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// defaultAWSRoleSessionName is the session name of the IAM role assumed by zctl, unless another one is given.
+const defaultAWSRoleSessionName = "zctl"
+
+// AWSOptions are the options of every AWS client created by zctl. The endpoints replace the endpoints of the AWS
+// services, so that zctl can run against LocalStack or other stand-ins for S3, IAM, STS and EKS. KMS is only reached
+// through the endpoint of every AWS service.
+// The credentials are those of the profile of the AWS configuration, or of the default chain of the AWS SDK when no
+// profile is given. When a role is given, they are only used to assume the role, whose credentials are then used
+// by every AWS client.
+type AWSOptions struct {
+	Profile         string // profile of the shared AWS configuration and credentials files
+	RoleArn         string // ARN of the IAM role to assume
+	ExternalID      string // external ID required by the trust policy of the role to assume
+	RoleSessionName string // session name of the assumed role
+	EndpointURL     string // endpoint of every AWS service, unless overridden for the service
+	S3EndpointURL   string // endpoint of S3
+	IAMEndpointURL  string // endpoint of IAM
+	STSEndpointURL  string // endpoint of STS
+	EKSEndpointURL  string // endpoint of EKS
+	S3PathStyle     bool   // path-style addressing for S3, always used with a custom S3 endpoint
+}
+
+// awsOptions are the options of the AWS clients, set once from the command line by SetAWSOptions.
+var awsOptions AWSOptions
+
+// awsCredentials is the credential provider shared by the AWS clients, built from the options by the first
+// loadAWSConfig. The credentials of an assumed role are cached and refreshed before they expire.
+var awsCredentials aws.CredentialsProvider
+
+// SetAWSOptions sets the options of the AWS clients created from then on.
+func SetAWSOptions(options AWSOptions) {
+	awsOptions = options
+	awsCredentials = nil
+}
+
+// ValidateAWSOptions checks that the options of the role to assume are only given along with the role.
+func ValidateAWSOptions(options AWSOptions) error {
+	if options.RoleArn == "" && (options.ExternalID != "" || options.RoleSessionName != "") {
+		return fmt.Errorf("an external ID or a role session name requires the ARN of the role to assume")
+	}
+	if options.RoleArn != "" && !strings.HasPrefix(options.RoleArn, "arn:") {
+		return fmt.Errorf("invalid ARN of the role to assume: %s", options.RoleArn)
+	}
+
+	return nil
+}
+
+// awsEndpoint returns the endpoint URL of an AWS service (s3, iam, sts, eks or kms), or an empty string when the
+// endpoint resolved by the AWS SDK is used.
+func awsEndpoint(service string) string {
+	override := map[string]string{
+		"s3":  awsOptions.S3EndpointURL,
+		"iam": awsOptions.IAMEndpointURL,
+		"sts": awsOptions.STSEndpointURL,
+		"eks": awsOptions.EKSEndpointURL,
+	}[service]
+	if override != "" {
+		return override
+	}
+
+	return awsOptions.EndpointURL
+}
+
+// loadAWSConfig loads the AWS configuration shared by the AWS clients, with the profile and the credentials given by
+// the options. The region of the configuration is used unless a region is given.
+func loadAWSConfig(region string) (aws.Config, error) {
+	optFns := []func(*config.LoadOptions) error{}
+	if awsOptions.Profile != "" {
+		optFns = append(optFns, config.WithSharedConfigProfile(awsOptions.Profile))
+	}
+	if region != "" {
+		optFns = append(optFns, config.WithRegion(region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background(), optFns...)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to load the AWS configuration: %v", err)
+	}
+
+	if awsCredentials == nil {
+		awsCredentials = newAWSCredentials(cfg)
+	}
+	cfg.Credentials = awsCredentials
+
+	return cfg, nil
+}
+
+// newAWSCredentials returns the credential provider of the AWS clients: the credentials of the configuration, or
+// those of the role assumed with them when a role is given.
+func newAWSCredentials(cfg aws.Config) aws.CredentialsProvider {
+	if awsOptions.RoleArn == "" {
+		return cfg.Credentials
+	}
+
+	if cfg.Region == "" {
+		// STS is reached through its global endpoint when no region is configured
+		cfg.Region = "us-east-1"
+	}
+	stsClient := sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if endpoint := awsEndpoint("sts"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+
+	return aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, awsOptions.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = awsOptions.RoleSessionName
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = defaultAWSRoleSessionName
+		}
+		if awsOptions.ExternalID != "" {
+			o.ExternalID = aws.String(awsOptions.ExternalID)
+		}
+	}))
+}
+
+// newS3Client creates an S3 client for the buckets of the region.
+func newS3Client(region string) (*s3.Client, error) {
+	cfg, err := loadAWSConfig(region)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := awsEndpoint("s3")
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = awsOptions.S3PathStyle || endpoint != ""
+	}), nil
+}
+
+// newIAMClient creates an IAM client for the partition of the region.
+func newIAMClient(region string) (*iam.Client, error) {
+	cfg, err := loadAWSConfig(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return iam.NewFromConfig(cfg, func(o *iam.Options) {
+		if endpoint := awsEndpoint("iam"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
+}
+
+// newSTSClient creates an STS client.
+func newSTSClient() (*sts.Client, error) {
+	cfg, err := loadAWSConfig("")
+	if err != nil {
+		return nil, err
+	}
+
+	return sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if endpoint := awsEndpoint("sts"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
+}
+
+// newEKSClient creates an EKS client for the clusters of the region.
+func newEKSClient(region string) (*eks.Client, error) {
+	cfg, err := loadAWSConfig(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return eks.NewFromConfig(cfg, func(o *eks.Options) {
+		if endpoint := awsEndpoint("eks"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
+}
+
+// newKMSClient creates a KMS client for the keys of the region.
+func newKMSClient(region string) (*kms.Client, error) {
+	cfg, err := loadAWSConfig(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return kms.NewFromConfig(cfg, func(o *kms.Options) {
+		if endpoint := awsEndpoint("kms"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
+}
@@ -2,27 +2,31 @@ package utils
 
 import (
 	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go-v2/aws"
 
 	"github.com/aws/aws-sdk-go-v2/service/eks"
 	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
 )
 
 // GetEKSClusterDetails retrieves details for the specified EKS cluster.
 // It returns a pointer to the Cluster object or an error if one occurs.
-func GetEKSClusterDetails(clusterName string) (*types.Cluster, error) {
-	// Load the AWS configuration.
-	cfg, err := config.LoadDefaultConfig(context.Background())
+func GetEKSClusterDetails(clusterName, region string) (*types.Cluster, error) {
+	// Create a new EKS client.
+	svc, err := newEKSClient(region)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create a new EKS client.
-	svc := eks.NewFromConfig(cfg)
-
 	// Call the DescribeCluster API to retrieve the cluster details.
 	resp, err := svc.DescribeCluster(context.Background(), &eks.DescribeClusterInput{
 		Name: aws.String(clusterName),
@@ -34,59 +38,163 @@ func GetEKSClusterDetails(clusterName string) (*types.Cluster, error) {
 	return resp.Cluster, nil
 }
 
+// GetEKSOIDCIssuer returns the OIDC issuer URL of the specified EKS cluster.
+// It returns an error when the cluster has no OIDC issuer.
+func GetEKSOIDCIssuer(clusterName, region string) (string, error) {
+	clusterDetails, err := GetEKSClusterDetails(clusterName, region)
+	if err != nil {
+		return "", err
+	}
+
+	if clusterDetails.Identity == nil || clusterDetails.Identity.Oidc == nil || clusterDetails.Identity.Oidc.Issuer == nil {
+		return "", fmt.Errorf("cluster %s has no OIDC issuer", clusterName)
+	}
+
+	return *clusterDetails.Identity.Oidc.Issuer, nil
+}
+
+// OIDCProviderArn returns the ARN of the IAM OIDC provider of the issuer in the account.
+func OIDCProviderArn(accountId, issuer string) string {
+	return fmt.Sprintf("arn:aws:iam::%s:oidc-provider/%s", accountId, strings.TrimPrefix(issuer, "https://"))
+}
+
 // HasOIDCProvider is a function that checks whether an OIDC provider has already been configured for an Amazon EKS cluster.
 // The function takes in the name of the cluster and its region as arguments.
+// The cluster must have an OIDC issuer, and the issuer must be registered as an OIDC provider in IAM.
 // The function returns a boolean value indicating whether an OIDC provider exists for the cluster and an error if one occurs.
 func HasOIDCProvider(clusterName, region string) (bool, error) {
-	// Load the default AWS SDK configuration.
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	issuer, err := GetEKSOIDCIssuer(clusterName, region)
 	if err != nil {
-		// Return an error if an error occurs while loading the configuration.
 		return false, err
 	}
 
-	// Create a new Amazon EKS client using the loaded configuration.
-	svc := eks.NewFromConfig(cfg)
+	accountId, err := GetAWSAccountID()
+	if err != nil {
+		return false, err
+	}
 
-	// Call the DescribeCluster API to retrieve information about the specified cluster.
-	resp, err := svc.DescribeCluster(context.TODO(), &eks.DescribeClusterInput{
-		Name: &clusterName,
+	// Create a new IAM client.
+	svc, err := newIAMClient(region)
+	if err != nil {
+		// Return an error if an error occurs while loading the configuration.
+		return false, err
+	}
+
+	// Check if the issuer of the cluster is registered as an OIDC provider in IAM.
+	_, err = svc.GetOpenIDConnectProvider(context.Background(), &iam.GetOpenIDConnectProviderInput{
+		OpenIDConnectProviderArn: aws.String(OIDCProviderArn(accountId, issuer)),
 	})
 	if err != nil {
-		// Return an error if an error occurs while calling the DescribeCluster API.
+		var notFound *iamtypes.NoSuchEntityException
+		if errors.As(err, &notFound) {
+			// Print a message indicating that the cluster does not have an OIDC provider configured and provide instructions for configuring one.
+			fmt.Println("Cluster does not have OIDC provider configured. Install with --create_oidc_provider, or run 'eksctl utils associate-iam-oidc-provider --region="+region+" --cluster=", clusterName, " --approve' to associate an OIDC provider with the cluster")
+			return false, nil
+		}
 		return false, err
 	}
 
-	// Check if the cluster has an OIDC provider configured.
-	if resp.Cluster.Identity.Oidc != nil {
-		// Print a message indicating that the cluster has an OIDC provider configured.
-		fmt.Println("Cluster has OIDC provider configured")
+	// Print a message indicating that the cluster has an OIDC provider configured.
+	fmt.Println("Cluster has OIDC provider configured")
+
+	return true, nil
+}
+
+// OIDCThumbprint returns the thumbprint IAM requires for the OIDC provider of an issuer: the hex encoded SHA-1
+// fingerprint of the root CA certificate the issuer host presents.
+func OIDCThumbprint(issuer string) (string, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return "", err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host += ":443"
+	}
+
+	conn, err := tls.Dial("tcp", host, &tls.Config{ServerName: u.Hostname()})
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to the OIDC issuer %s: %v", issuer, err)
+	}
+	defer conn.Close()
+
+	certificates := conn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return "", fmt.Errorf("the OIDC issuer %s presented no certificate", issuer)
+	}
 
-		// Return true to indicate that an OIDC provider exists for the cluster.
-		return true, nil
-	} else {
-		// Print a message indicating that the cluster does not have an OIDC provider configured and provide instructions for configuring one.
-		fmt.Println("Cluster does not have OIDC provider configured. Run 'eksctl utils associate-iam-oidc-provider --region="+region+" --cluster=", clusterName, " --approve' to associate an OIDC provider with the cluster")
+	fingerprint := sha1.Sum(certificates[len(certificates)-1].Raw)
+
+	return hex.EncodeToString(fingerprint[:]), nil
+}
 
-		// Return false to indicate that an OIDC provider does not exist for the cluster.
-		return false, nil
+// CreateOIDCProvider registers the OIDC issuer of the specified EKS cluster as an OIDC provider in IAM, so that
+// service accounts of the cluster can assume IAM roles. The provider is shared by the whole cluster and is never
+// deleted by zctl. It returns the ARN of the provider.
+func CreateOIDCProvider(clusterName, region string) (string, error) {
+	issuer, err := GetEKSOIDCIssuer(clusterName, region)
+	if err != nil {
+		return "", err
 	}
 
+	thumbprint, err := OIDCThumbprint(issuer)
+	if err != nil {
+		return "", err
+	}
+
+	// Create a new IAM client.
+	svc, err := newIAMClient(region)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := svc.CreateOpenIDConnectProvider(context.Background(), &iam.CreateOpenIDConnectProviderInput{
+		Url:            aws.String(issuer),
+		ClientIDList:   []string{"sts.amazonaws.com"},
+		ThumbprintList: []string{thumbprint},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create the IAM OIDC provider of cluster %s: %v", clusterName, err)
+	}
+
+	fmt.Println("Created IAM OIDC provider: ", aws.ToString(resp.OpenIDConnectProviderArn))
+
+	return aws.ToString(resp.OpenIDConnectProviderArn), nil
+}
+
+// EnsureOIDCProvider checks that the specified EKS cluster has an OIDC issuer registered as an OIDC provider in IAM.
+// When it is not registered, it is created if create is set; otherwise an error explains how to create it.
+// It returns the issuer URL of the cluster.
+func EnsureOIDCProvider(clusterName, region string, create bool) (string, error) {
+	exists, err := HasOIDCProvider(clusterName, region)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		if !create {
+			return "", fmt.Errorf("the OIDC issuer of cluster %s is not registered as an IAM OIDC provider. Install with --create_oidc_provider to create it, or run 'eksctl utils associate-iam-oidc-provider --region=%s --cluster=%s --approve'", clusterName, region, clusterName)
+		}
+		_, err = CreateOIDCProvider(clusterName, region)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return GetEKSOIDCIssuer(clusterName, region)
 }
 
 // GetEksClusterNameByApiServerUrl is a function that retrieves the name of an Amazon EKS cluster using its API server URL.
 // The function takes in the API server URL as an argument.
 // The function returns the name of the cluster and an error if one occurs.
-func GetEksClusterNameByApiServerUrl(apiServerUrl string) (string, error) {
-	// Load the default AWS SDK configuration.
-	cfg, err := config.LoadDefaultConfig(context.Background())
+func GetEksClusterNameByApiServerUrl(apiServerUrl, region string) (string, error) {
+	// Create a new Amazon EKS client.
+	svc, err := newEKSClient(region)
 	if err != nil {
 		return "", err
 	}
 
-	// Create a new Amazon EKS client using the loaded configuration.
-	svc := eks.NewFromConfig(cfg)
-
 	// List all Amazon EKS clusters in the current AWS account and region.
 	resp, err := svc.ListClusters(context.TODO(), &eks.ListClustersInput{})
 	if err != nil {
@@ -121,7 +229,7 @@ func GetEksClusterNameByApiServerUrl(apiServerUrl string) (string, error) {
 // The function first retrieves the API server endpoint of the current Kubernetes context by calling the GetCurrentKubeContextAPIEndpoint function.
 // The function then retrieves the name of the Amazon EKS cluster associated with the API server endpoint by calling the GetEksClusterNameByApiServerUrl function.
 // The function returns the name of the Amazon EKS cluster and an error if one occurs.
-func GetCurrentEKSClusterName() (string, error) {
+func GetCurrentEKSClusterName(region string) (string, error) {
 	// Retrieve the API server endpoint of the current Kubernetes context.
 	apiEndpoint, err := GetCurrentKubeContextAPIEndpoint()
 	if err != nil {
@@ -130,7 +238,7 @@ func GetCurrentEKSClusterName() (string, error) {
 	}
 
 	// Retrieve the name of the Amazon EKS cluster associated with the API server endpoint.
-	clusterName, err := GetEksClusterNameByApiServerUrl(apiEndpoint)
+	clusterName, err := GetEksClusterNameByApiServerUrl(apiEndpoint, region)
 	if err != nil {
 		// Return an error if an error occurs while retrieving the Amazon EKS cluster name.
 		return "", err
@@ -2,82 +2,314 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	admin "cloud.google.com/go/iam/admin/apiv1"
 	"cloud.google.com/go/iam/admin/apiv1/adminpb"
 	"cloud.google.com/go/iam/apiv1/iampb"
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-func SetupGCP(setupData SetupData) (SetupData, error) {
+// SetupGCP creates the GCS bucket, the service account, its access to the bucket and its HMAC key.
+// Every created resource is recorded in the journal as soon as it is created. Resources already held by the
+// journal of a resumed install are not created again.
+func SetupGCP(setupData SetupData, journal *Journal) (SetupData, error) {
 	// 1. Create bucket
 	bucketName := "zinc-observe-" + setupData.Identifier + "-" + setupData.ReleaseName
 	setupData.BucketName = bucketName
 
-	err := CreateBucket(setupData.GCPProjectId, setupData.BucketName)
-	if err != nil {
-		fmt.Println(err)
+	if _, ok := journal.Find(ResourceGCSBucket); ok {
+		fmt.Println("Bucket already created: ", bucketName)
+	} else {
+		err := CreateBucket(setupData.GCPProjectId, setupData.BucketName)
+		if err != nil {
+			fmt.Println(err)
+			return setupData, err
+		}
+		err = journal.Record(setupData, ResourceGCSBucket, setupData.BucketName, "")
+		if err != nil {
+			return setupData, err
+		}
 	}
 
 	// 2. Create service account
-	serviceAccount, err := CreateGCPServiceAccount(setupData.GCPProjectId, setupData.Identifier)
-	if err != nil {
-		fmt.Println(err)
+	if entry, ok := journal.Find(ResourceGCPServiceAccount); ok {
+		fmt.Println("Service account already created: ", entry.Name)
+		setupData.ServiceAccount = entry.Name
+	} else {
+		serviceAccount, err := CreateGCPServiceAccount(setupData.GCPProjectId, setupData.Identifier)
+		if err != nil {
+			fmt.Println(err)
+			return setupData, err
+		}
+		setupData.ServiceAccount = serviceAccount.Email
+		err = journal.Record(setupData, ResourceGCPServiceAccount, serviceAccount.Email, "")
+		if err != nil {
+			return setupData, err
+		}
 	}
 
-	setupData.ServiceAccount = serviceAccount.Email
+	// The access to the bucket is granted before the HMAC key is created, so a recorded key means both steps are done
+	if entry, ok := journal.Find(ResourceHMACKey); ok {
+		if setupData.S3AccessKey != entry.Name || setupData.S3SecretKey == "" {
+			return setupData, fmt.Errorf("the secret of HMAC key %s was not recorded, run 'zctl --name=%s uninstall' and install again", entry.Name, setupData.ReleaseName)
+		}
+		fmt.Println("HMAC key already created: ", entry.Name)
+		setupData.HMACAccessID = entry.Name
+
+		return setupData, nil
+	}
 
 	// 3. Grant access to service account to the bucket
-	err = GrantAllAccessToBucket(setupData.GCPProjectId, setupData.BucketName, serviceAccount.Email)
+	err := GrantAllAccessToBucket(setupData.GCPProjectId, setupData.BucketName, setupData.ServiceAccount)
 	if err != nil {
 		fmt.Println(err)
+		return setupData, err
 	}
 
 	// 4. Create HMAC key
-	key, err := CreateHMACKey(setupData.GCPProjectId, serviceAccount.Email)
+	key, err := CreateHMACKey(setupData.GCPProjectId, setupData.ServiceAccount)
 	if err != nil {
 		fmt.Println(err)
+		return setupData, err
 	}
 
+	// The credentials are checkpointed with the key, so that a resumed install can reuse them
+	setupData.HMACAccessID = key.AccessID
 	setupData.S3AccessKey = key.AccessID
 	setupData.S3SecretKey = key.Secret
+	err = journal.Record(setupData, ResourceHMACKey, key.AccessID, "")
+	if err != nil {
+		return setupData, err
+	}
 
 	return setupData, nil
 }
 
-// DeleteGCPServiceAccount deletes a service account
+// TearDownGCP tears down the GCP resources associated with a given release.
+// It deactivates and deletes the recorded HMAC key, reports the other HMAC keys of the service account that are
+// left behind, and deletes the service account. The bucket is kept. Every step runs, and resources that are
+// already gone count as deleted; the errors of the steps that failed are returned together.
+func TearDownGCP(setupData SetupData) error {
+	failures := []string{}
+
+	if setupData.HMACAccessID != "" {
+		err := DeleteHMACKey(setupData.GCPProjectId, setupData.HMACAccessID)
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	keys, err := ListHMACKeys(setupData.GCPProjectId, setupData.ServiceAccount)
+	if err != nil {
+		fmt.Println("error listing the HMAC keys of service account ", setupData.ServiceAccount, ": ", err)
+	} else if len(keys) > 0 {
+		fmt.Printf("HMAC keys left behind for service account %s, they stop working when it is deleted: %s\n", setupData.ServiceAccount, strings.Join(keys, ", "))
+	}
+
+	err = DeleteGCPServiceAccount(setupData)
+	if err != nil {
+		failures = append(failures, err.Error())
+	}
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// ListHMACKeys returns the access IDs of the HMAC keys of a service account that are not deleted.
+func ListHMACKeys(projectID, serviceAccountEmail string) ([]string, error) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	accessIDs := []string{}
+	it := client.ListHMACKeys(ctx, projectID, storage.ForHMACKeyServiceAccountEmail(serviceAccountEmail))
+	for {
+		key, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key.State != storage.Deleted {
+			accessIDs = append(accessIDs, key.AccessID)
+		}
+	}
+
+	return accessIDs, nil
+}
+
+// DeleteGCPServiceAccount deletes a service account. A service account that does not exist counts as deleted.
 func DeleteGCPServiceAccount(setupData SetupData) error {
 	ctx := context.Background()
 	client, err := admin.NewIamClient(ctx)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return fmt.Errorf("failed to create client: %v", err)
 	}
 	defer client.Close()
 
-	// Delete the service account.
+	// Delete the service account. A service account that does not exist counts as deleted.
 	if err := client.DeleteServiceAccount(ctx, &adminpb.DeleteServiceAccountRequest{
 		Name: fmt.Sprintf("projects/%s/serviceAccounts/%s", setupData.GCPProjectId, setupData.ServiceAccount),
 	}); err != nil {
-		log.Fatalf("Failed to delete service account: %v", err)
+		if status.Code(err) == codes.NotFound {
+			fmt.Println("Service account already deleted: ", setupData.ServiceAccount)
+			return nil
+		}
+		return fmt.Errorf("failed to delete service account: %v", err)
 	}
 
 	return nil
 }
 
-func DeleteGCSBucket(setupData SetupData) {
+// DeleteGCSBucket deletes the bucket of the setup data. The bucket must be empty.
+func DeleteGCSBucket(setupData SetupData) error {
 	ctx := context.Background()
 	client, err := storage.NewClient(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer client.Close()
 
 	err = client.Bucket(setupData.BucketName).Delete(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	fmt.Printf("Bucket %s deleted successfully\n", setupData.BucketName)
+
+	return nil
+}
+
+// EmptyGCSBucket deletes all the objects of the bucket, including every noncurrent generation.
+// The objects are listed page by page by the iterator and deleted by a pool of parallel workers.
+func EmptyGCSBucket(bucketName string) error {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	fmt.Println("Emptying bucket: ", bucketName)
+
+	bucket := client.Bucket(bucketName)
+	objects := make(chan *storage.ObjectAttrs)
+	errs := make(chan error, purgeWorkers)
+	var deleted int64
+
+	var wg sync.WaitGroup
+	for i := 0; i < purgeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for attrs := range objects {
+				err := bucket.Object(attrs.Name).Generation(attrs.Generation).Delete(ctx)
+				if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+					select {
+					case errs <- fmt.Errorf("failed to delete %s: %v", attrs.Name, err):
+					default:
+					}
+					continue
+				}
+				atomic.AddInt64(&deleted, 1)
+			}
+		}()
+	}
+
+	var listErr error
+	it := bucket.Objects(ctx, &storage.Query{Versions: true})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			listErr = err
+			break
+		}
+		objects <- attrs
+	}
+
+	close(objects)
+	wg.Wait()
+	close(errs)
+
+	if listErr != nil {
+		return listErr
+	}
+	if err := <-errs; err != nil {
+		return err
 	}
+
+	fmt.Printf("Deleted %d objects from bucket %s\n", deleted, bucketName)
+
+	return nil
+}
+
+// DeleteHMACKey deactivates and deletes an HMAC key. A key that is already gone counts as deleted.
+func DeleteHMACKey(projectID, accessID string) error {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	handle := client.HMACKeyHandle(projectID, accessID)
+
+	key, err := handle.Get(ctx)
+	if err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to get HMAC key %s: %v", accessID, err)
+	}
+
+	if key.State == storage.Deleted {
+		return nil
+	}
+
+	// Only inactive keys can be deleted
+	if key.State == storage.Active {
+		_, err = handle.Update(ctx, storage.HMACKeyAttrsToUpdate{State: storage.Inactive})
+		if err != nil {
+			return fmt.Errorf("failed to deactivate HMAC key %s: %v", accessID, err)
+		}
+	}
+
+	err = handle.Delete(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete HMAC key %s: %v", accessID, err)
+	}
+
+	fmt.Println("Deleted HMAC key: ", accessID)
+
+	return nil
 }
 
 func GrantAllAccessToBucket(projectID, bucketName, serviceAccountEmail string) error {
@@ -88,13 +320,13 @@ func GrantAllAccessToBucket(projectID, bucketName, serviceAccountEmail string) e
 
 		client, err := storage.NewClient(ctx)
 		if err != nil {
-			log.Fatalf("Failed to create client: %v", err)
+			return fmt.Errorf("failed to create client: %v", err)
 		}
 		defer client.Close()
 
 		policy, err := client.Bucket(bucketName).IAM().V3().Policy(ctx)
 		if err != nil {
-			log.Fatalf("Failed to get bucket policy: %v", err)
+			return fmt.Errorf("failed to get bucket policy: %v", err)
 		}
 
 		newBinding := iampb.Binding{
@@ -105,7 +337,7 @@ func GrantAllAccessToBucket(projectID, bucketName, serviceAccountEmail string) e
 		policy.Bindings = append(policy.Bindings, &newBinding)
 
 		if err := client.Bucket(bucketName).IAM().V3().SetPolicy(ctx, policy); err != nil {
-			log.Fatalf("Failed to update bucket policy: %v", err)
+			return fmt.Errorf("failed to update bucket policy: %v", err)
 		}
 
 		fmt.Printf("Successfully granted %s access to %s for service account %s\n", role, bucketName, serviceAccountEmail)
@@ -114,6 +346,59 @@ func GrantAllAccessToBucket(projectID, bucketName, serviceAccountEmail string) e
 	return nil
 }
 
+// GCSBucketHasBinding checks whether the IAM policy of the bucket grants the role to the member, e.g. serviceAccount:<email>.
+func GCSBucketHasBinding(bucketName, role, member string) (bool, error) {
+	ctx := context.Background()
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	policy, err := client.Bucket(bucketName).IAM().V3().Policy(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get bucket policy: %v", err)
+	}
+
+	for _, binding := range policy.Bindings {
+		if binding.Role != role {
+			continue
+		}
+		for _, m := range binding.Members {
+			if m == member {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// GetHMACKeyState returns the state (ACTIVE, INACTIVE or DELETED) of an HMAC key, or an empty string when the key does not exist.
+func GetHMACKeyState(projectID, accessID string) (string, error) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return "", fmt.Errorf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	key, err := client.HMACKeyHandle(projectID, accessID).Get(ctx)
+	if err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get HMAC key %s: %v", accessID, err)
+	}
+
+	return string(key.State), nil
+}
+
 // CreateHMACKey creates a new HMAC key using the given project and service account.
 func CreateHMACKey(projectID string, serviceAccountEmail string) (*storage.HMACKey, error) {
 	ctx := context.Background()
@@ -132,7 +417,7 @@ func CreateHMACKey(projectID string, serviceAccountEmail string) (*storage.HMACK
 		return nil, fmt.Errorf("CreateHMACKey: %v", err)
 	}
 
-	fmt.Println("Created HMAC key: ", key)
+	fmt.Println("Created HMAC key: ", key.AccessID)
 
 	return key, nil
 }
@@ -142,7 +427,7 @@ func CreateBucket(projectID, bucketName string) error {
 
 	client, err := storage.NewClient(ctx)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return fmt.Errorf("failed to create client: %v", err)
 	}
 	defer client.Close()
 
@@ -153,8 +438,7 @@ func CreateBucket(projectID, bucketName string) error {
 	}
 
 	if err := bucket.Create(ctx, projectID, bucketAttrs); err != nil {
-		log.Fatalf("Failed to create bucket: %v", err)
-		return fmt.Errorf("Failed to create bucket: %v", err)
+		return fmt.Errorf("failed to create bucket: %v", err)
 	}
 
 	fmt.Printf("Bucket %s created successfully\n", bucketName)
@@ -168,7 +452,7 @@ func CreateGCPServiceAccount(projectID, serviceAccountName string) (*adminpb.Ser
 	ctx := context.Background()
 	client, err := admin.NewIamClient(ctx)
 	if err != nil {
-		log.Fatalf("Failed to create IAM client: %v", err)
+		return nil, fmt.Errorf("failed to create IAM client: %v", err)
 	}
 	defer client.Close()
 
@@ -200,3 +484,45 @@ func CreateGCPServiceAccount(projectID, serviceAccountName string) (*adminpb.Ser
 
 	return serviceAccount, nil
 }
+
+// GCSBucketExists checks whether the specified GCS bucket exists.
+func GCSBucketExists(bucketName string) (bool, error) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	_, err = client.Bucket(bucketName).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrBucketNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GCPServiceAccountExists checks whether the specified service account exists in the project.
+func GCPServiceAccountExists(projectID, serviceAccountEmail string) (bool, error) {
+	ctx := context.Background()
+	client, err := admin.NewIamClient(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	_, err = client.GetServiceAccount(ctx, &adminpb.GetServiceAccountRequest{
+		Name: fmt.Sprintf("projects/%s/serviceAccounts/%s", projectID, serviceAccountEmail),
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
@@ -5,84 +5,269 @@ import (
 	"fmt"
 
 	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+const (
+	// DefaultChartVersion is the version of the ZincObserve Helm chart installed when none is specified.
+	DefaultChartVersion = "0.3.3"
+	// DefaultAppVersion is the ZincObserve version reported in the chart metadata.
+	DefaultAppVersion = "v0.3.1"
+	// DefaultImageRepository is the repository of the ZincObserve container image.
+	DefaultImageRepository = "public.ecr.aws/zinclabs/zincobserve"
+	// DefaultImageTag is the tag of the ZincObserve container image used when none is specified.
+	DefaultImageTag = "v0.3.2"
 )
 
 // SetupHelm sets up the necessary kubernetes resources using official Helm chart.
-// It returns the name of the S3 bucket and the IAM role ARN that were created.
-// If an error occurs, it returns an empty string for both values and the error itself.
 // It requires the name of the release, the namespace to deploy to, the name of the S3 bucket, and the IAM role ARN.
 // If namespace is an empty string, it will default to "default". If namespace does not exist, it will be created.
+// It returns the revision of the installed release.
 // func SetupHelm(releaseName, namespace, bucket, role string) error {
-func SetupHelm(setupData SetupData) error {
+func SetupHelm(setupData SetupData) (int, error) {
 	// arn:aws:iam::12345353456:role/zo-s3-eks
 
+	h1, chart, context, err := prepareHelmChart(setupData)
+	if err != nil {
+		return 0, err
+	}
+
+	// Install the Helm chart with the updated values on the specified Kubernetes cluster context.
+	rel, err := h1.Install(chart, context)
+	if err != nil {
+		// Print an error message if an error occurs while installing the Helm chart.
+		fmt.Println("error installing: ", err)
+		return 0, err
+	}
+
+	return rel.Version, nil
+
+}
+
+// UpgradeHelm upgrades an existing release in place using the chart values rebuilt from the setup data.
+// Bucket, IAM role and HMAC key wiring is taken from the setup data, so it stays intact across upgrades.
+// It returns the revision of the upgraded release.
+func UpgradeHelm(setupData SetupData) (int, error) {
+	h1, chart, context, err := prepareHelmChart(setupData)
+	if err != nil {
+		return 0, err
+	}
+
+	// Upgrade the Helm release with the updated values on the specified Kubernetes cluster context.
+	rel, err := h1.Upgrade(chart, context)
+	if err != nil {
+		// Print an error message if an error occurs while upgrading the Helm release.
+		fmt.Println("error upgrading: ", err)
+		return 0, err
+	}
+
+	return rel.Version, nil
+}
+
+// HelmHistory returns the revisions of the release described by the setup data, oldest first.
+func HelmHistory(setupData SetupData) ([]*release.Release, error) {
+	context, err := CurrentKubeContext()
+	if err != nil {
+		return nil, err
+	}
+
+	return History(context, setupData.ReleaseName, setupData.Namespace)
+}
+
+// RollbackHelm rolls back the release described by the setup data to the given revision.
+// It returns the revision created by the rollback.
+func RollbackHelm(setupData SetupData, revision int) (int, error) {
+	context, err := CurrentKubeContext()
+	if err != nil {
+		return 0, err
+	}
+
+	newRevision, err := Rollback(context, setupData.ReleaseName, setupData.Namespace, revision)
+	if err != nil {
+		// Print an error message if an error occurs while rolling back the Helm release.
+		fmt.Println("error rolling back: ", err)
+		return 0, err
+	}
+
+	return newRevision, nil
+}
+
+// ReleaseVersions holds the versions a ZincObserve release is deployed with.
+type ReleaseVersions struct {
+	ChartVersion string
+	AppVersion   string
+	ImageTag     string
+	Revision     int
+	Status       string
+}
+
+// DeployedReleaseVersions returns the chart, app and image versions of the specified release,
+// as recorded in the Helm release metadata of the current kube context.
+func DeployedReleaseVersions(releaseName, namespace string) (ReleaseVersions, error) {
+	versions := ReleaseVersions{}
+
+	context, err := CurrentKubeContext()
+	if err != nil {
+		return versions, err
+	}
+
+	rel, err := GetRelease(context, releaseName, namespace)
+	if err != nil {
+		return versions, err
+	}
+
+	versions.ChartVersion = rel.Chart.Metadata.Version
+	versions.AppVersion = rel.Chart.Metadata.AppVersion
+	versions.Revision = rel.Version
+	versions.Status = rel.Info.Status.String()
+
+	// The image tag is set in the chart values by setUpChartValues.
+	if image, ok := rel.Chart.Values["image"].(map[string]interface{}); ok {
+		if tag, ok := image["tag"].(string); ok {
+			versions.ImageTag = tag
+		}
+	}
+
+	return versions, nil
+}
+
+// CurrentKubeContext returns the name of the kube context pointing at the Kubernetes cluster currently in use.
+func CurrentKubeContext() (string, error) {
 	// Retrieve the URL of the Kubernetes cluster currently in use.
 	clusterURL, err := GetCurrentKubeContextAPIEndpoint()
 	if err != nil {
-		// Print an error message if an error occurs while retrieving the cluster URL.
-		fmt.Println("error: ", err)
-		return err
+		return "", err
 	}
 
 	// Retrieve the context of the Kubernetes cluster using its URL.
-	context, err := KubeContextForCluster(clusterURL)
+	return KubeContextForCluster(clusterURL)
+}
+
+// SetupKubeContext returns the kube context recorded in the setup data at install time, or the current kube context
+// for setup data written before the context was recorded.
+func SetupKubeContext(setupData SetupData) (string, error) {
+	if setupData.KubeContext != "" {
+		return setupData.KubeContext, nil
+	}
+
+	return CurrentKubeContext()
+}
+
+// prepareHelmChart resolves the current kube context, downloads the chart version recorded in the setup data
+// and sets up its values. It returns the Helm object, the chart and the kube context to deploy to.
+func prepareHelmChart(setupData SetupData) (*Helm, *chart.Chart, string, error) {
+	// Retrieve the context of the Kubernetes cluster currently in use.
+	context, err := CurrentKubeContext()
 	if err != nil {
 		// Print an error message if an error occurs while retrieving the context.
 		fmt.Println("error: ", err)
-		return err
+		return nil, nil, "", err
+	}
+
+	h1, chart, err := loadHelmChart(setupData, "")
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return h1, chart, context, nil
+}
+
+// loadHelmChart downloads the chart version recorded in the setup data, or loads the chart at chartPath when it is set,
+// and sets up its values, without contacting the cluster. It returns the Helm object and the chart.
+func loadHelmChart(setupData SetupData, chartPath string) (*Helm, *chart.Chart, error) {
+	chartVersion := setupData.ChartVersion
+	if chartVersion == "" {
+		chartVersion = DefaultChartVersion
 	}
 
 	// Create a new Helm object with the required deployment parameters.
-	h1 := Helm{
-		AppVersion:    "v0.3.1",
+	h1 := &Helm{
+		AppVersion:    DefaultAppVersion,
 		ChartName:     "zincobserve",
-		ChartVersion:  "0.3.3",
+		ChartVersion:  chartVersion,
 		Namespace:     setupData.Namespace,
 		ReleaseName:   setupData.ReleaseName,
 		RepositoryURL: "https://charts.zinc.dev",
 	}
 
-	// Download the Helm chart specified by the Helm object.
-	chart, err := h1.DownloadChart()
-	if err != nil {
-		// Print an error message if an error occurs while downloading the chart.
-		fmt.Println("error downloading: ", err)
-		return err
+	var chart *chart.Chart
+	var err error
+	if chartPath != "" {
+		// Load the Helm chart from the local path.
+		chart, err = loader.Load(chartPath)
+		if err != nil {
+			fmt.Println("error loading chart: ", err)
+			return nil, nil, err
+		}
+	} else {
+		// Download the Helm chart specified by the Helm object.
+		chart, err = h1.DownloadChart()
+		if err != nil {
+			// Print an error message if an error occurs while downloading the chart.
+			fmt.Println("error downloading: ", err)
+			return nil, nil, err
+		}
 	}
 
 	chart.Values, err = setUpChartValues(chart.Values, setupData)
 	if err != nil {
 		// Print an error message if an error occurs while setting up the chart values.
 		fmt.Println("error setting up chart values: ", err)
-		return err
+		return nil, nil, err
 	}
 
-	// Install the Helm chart with the updated values on the specified Kubernetes cluster context.
-	err = h1.Install(chart, context)
+	return h1, chart, nil
+}
+
+// RenderHelmChart renders the manifests of the chart with the values set up from the setup data, using Helm's client-only dry run.
+// The chart is downloaded, or loaded from chartPath when it is set. Nothing is created in the cluster.
+// It returns the effective values and the rendered release.
+func RenderHelmChart(setupData SetupData, chartPath string) (map[string]interface{}, *release.Release, error) {
+	h1, chart, err := loadHelmChart(setupData, chartPath)
 	if err != nil {
-		// Print an error message if an error occurs while installing the Helm chart.
-		fmt.Println("error installing: ", err)
-		return err
+		return nil, nil, err
 	}
 
-	return nil
+	rel, err := h1.Template(chart)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	return chart.Values, rel, nil
 }
 
-func TearDownHelm(releaseName, namespace string) {
+// TearDownHelm uninstalls the release described by the setup data, from the kube context and namespace and with the
+// Helm storage driver recorded at install time. A release that does not exist counts as uninstalled.
+func TearDownHelm(setupData SetupData) error {
+	context, err := SetupKubeContext(setupData)
+	if err != nil {
+		fmt.Println("error: ", err)
+		return err
+	}
+
+	// Setup data written before the driver was recorded was installed with the default driver
+	helmDriver := setupData.HelmDriver
+	if helmDriver == "" {
+		helmDriver = HelmStorageDriver
+	}
+
 	// Create a new Helm object with the required deployment parameters.
 	h1 := Helm{
-		Namespace:   namespace,
-		ReleaseName: releaseName,
+		Namespace:   setupData.Namespace,
+		ReleaseName: setupData.ReleaseName,
 	}
 
 	// Uninstall the Helm chart on the specified Kubernetes cluster context.
-	err := h1.UnInstall(releaseName, namespace)
+	err = h1.UnInstall(context, setupData.ReleaseName, setupData.Namespace, helmDriver)
 	if err != nil {
 		// Print an error message if an error occurs while uninstalling the Helm chart.
 		fmt.Println("error uninstalling: ", err)
+		return err
 	}
 
+	return nil
 }
 
 func setUpChartValues(baseValuesMap map[string]interface{}, setupData SetupData) (map[string]interface{}, error) {
@@ -106,10 +291,33 @@ func setUpChartValues(baseValuesMap map[string]interface{}, setupData SetupData)
 	}
 
 	data.Config.ZOS3BUCKETNAME = setupData.BucketName
-	data.Image.Repository = "public.ecr.aws/zinclabs/zincobserve"
-	data.Image.Tag = "v0.3.2"
+	data.Image.Repository = DefaultImageRepository
+	data.Image.Tag = DefaultImageTag
+	if setupData.ImageTag != "" {
+		data.Image.Tag = setupData.ImageTag
+	}
+
+	// Override the replica counts of the components that were explicitly set.
+	if setupData.Replicas.Ingester > 0 {
+		data.ReplicaCount.Ingester = setupData.Replicas.Ingester
+	}
+	if setupData.Replicas.Querier > 0 {
+		data.ReplicaCount.Querier = setupData.Replicas.Querier
+	}
+	if setupData.Replicas.Router > 0 {
+		data.ReplicaCount.Router = setupData.Replicas.Router
+	}
+	if setupData.Replicas.Alertmanager > 0 {
+		data.ReplicaCount.Alertmanager = setupData.Replicas.Alertmanager
+	}
+	if setupData.Replicas.Compactor > 0 {
+		data.ReplicaCount.Compactor = setupData.Replicas.Compactor
+	}
 
-	if setupData.K8s == "eks" {
+	if setupData.K8s == "eks" && setupData.AWSIdentity == AWSIdentityPodIdentity {
+		// The role is associated with the service account by EKS Pod Identity, which needs its name ahead of the install
+		data.ServiceAccount.Name = PodIdentityServiceAccountName(setupData)
+	} else if setupData.K8s == "eks" {
 		data.ServiceAccount.Annotations["eks.amazonaws.com/role-arn"] = setupData.IamRole
 	} else if setupData.K8s == "gke" {
 		data.Auth.ZOS3ACCESSKEY = setupData.S3AccessKey
@@ -132,6 +340,11 @@ func setUpChartValues(baseValuesMap map[string]interface{}, setupData SetupData)
 		return nil, fmt.Errorf("invalid k8s provider. Valid values are: eks, gke, plain")
 	}
 
+	// The bucket is addressed in the region it is located in, which an adopted bucket may not share with the cluster
+	if setupData.K8s == "eks" && S3BucketRegion(setupData) != "" {
+		data.Config.ZOS3REGIONNAME = S3BucketRegion(setupData)
+	}
+
 	// Update the Helm chart values with the AWS bucket name and role ARN.
 
 	// yamlData, err := yaml.Marshal(&data)
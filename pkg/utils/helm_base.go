@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
@@ -16,7 +17,9 @@ import (
 	"helm.sh/helm/v3/pkg/getter"
 	"helm.sh/helm/v3/pkg/postrender"
 	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
 	"helm.sh/helm/v3/pkg/repo"
+	"helm.sh/helm/v3/pkg/storage/driver"
 	"helm.sh/helm/v3/pkg/strvals"
 )
 
@@ -34,9 +37,18 @@ type Helm struct {
 	ValuesFile string
 }
 
+// HelmStorageDriver is the Helm storage driver releases are installed with. It is recorded in the setup data.
+const HelmStorageDriver = "secret"
+
 // initialize creates and initializes a new Helm action configuration object with the specified Kubernetes context and namespace.
 // It returns a pointer to the Configuration object or an error if one occurs.
 func initialize(kubeContext, namespace string) (*action.Configuration, error) {
+	return initializeWithDriver(kubeContext, namespace, HelmStorageDriver)
+}
+
+// initializeWithDriver creates and initializes a new Helm action configuration object with the specified Kubernetes
+// context, namespace and Helm storage driver.
+func initializeWithDriver(kubeContext, namespace, helmDriver string) (*action.Configuration, error) {
 	// Workaround for https://github.com/helm/helm/issues/7430.
 	_ = os.Setenv("HELM_KUBECONTEXT", kubeContext)
 	_ = os.Setenv("HELM_NAMESPACE", namespace)
@@ -46,7 +58,7 @@ func initialize(kubeContext, namespace string) (*action.Configuration, error) {
 
 	// Initialize the action configuration.
 	actionConfig := new(action.Configuration)
-	if err := actionConfig.Init(settings.RESTClientGetter(), namespace, "secret", log.Printf); err != nil {
+	if err := actionConfig.Init(settings.RESTClientGetter(), namespace, helmDriver, log.Printf); err != nil {
 		return nil, fmt.Errorf("failed to initialize helm action config: %w", err)
 	}
 
@@ -107,25 +119,25 @@ func (h *Helm) DownloadChart() (*chart.Chart, error) {
 	return chart, nil
 }
 
-// Install deploys the specified Helm chart with the given parameters, and returns an error if one occurs.
-func (h *Helm) Install(chart *chart.Chart, kubeContext string) error {
+// Install deploys the specified Helm chart with the given parameters, and returns the installed release or an error if one occurs.
+func (h *Helm) Install(chart *chart.Chart, kubeContext string) (*release.Release, error) {
 	// Parse the values file.
 	values := map[string]interface{}{}
 	if err := yaml.Unmarshal([]byte(h.ValuesFile), &values); err != nil {
-		return fmt.Errorf("failed to parse values file: %w", err)
+		return nil, fmt.Errorf("failed to parse values file: %w", err)
 	}
 
 	// Parse the set values and add them to the values map.
 	for _, v := range h.SetValues {
 		if err := strvals.ParseInto(v, values); err != nil {
-			return fmt.Errorf("failed parsing --set data: %w", err)
+			return nil, fmt.Errorf("failed parsing --set data: %w", err)
 		}
 	}
 
 	// Initialize the Helm action configuration.
 	actionConfig, err := initialize(kubeContext, h.Namespace)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Configure the Helm install options.
@@ -143,7 +155,7 @@ func (h *Helm) Install(chart *chart.Chart, kubeContext string) error {
 	fmt.Println("Installing using helm chart...")
 	rel, err := instAction.Run(chart, values)
 	if err != nil {
-		return fmt.Errorf("helm install failed: %s", err)
+		return nil, fmt.Errorf("helm install failed: %s", err)
 	}
 
 	// Print the chart installation details.
@@ -155,21 +167,121 @@ func (h *Helm) Install(chart *chart.Chart, kubeContext string) error {
 		fmt.Printf("NOTES:\n%s\n", strings.TrimSpace(rel.Info.Notes))
 	}
 
-	return nil
+	return rel, nil
 }
 
-func (h *Helm) UnInstall(releaseName, namespace string) error {
+// Template renders the specified Helm chart with the given parameters without contacting the cluster (Helm's client-only dry run).
+// It returns the rendered release, whose Manifest and Hooks hold the rendered manifests, or an error if one occurs.
+func (h *Helm) Template(chart *chart.Chart) (*release.Release, error) {
+	// Parse the values file.
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(h.ValuesFile), &values); err != nil {
+		return nil, fmt.Errorf("failed to parse values file: %w", err)
+	}
 
-	kubeConfig := cli.New()
+	// Parse the set values and add them to the values map.
+	for _, v := range h.SetValues {
+		if err := strvals.ParseInto(v, values); err != nil {
+			return nil, fmt.Errorf("failed parsing --set data: %w", err)
+		}
+	}
+
+	// A client-only install uses in-memory release storage and default capabilities.
+	actionConfig := &action.Configuration{Log: func(string, ...interface{}) {}}
+
+	// Configure the Helm install options.
+	instAction := action.NewInstall(actionConfig)
+	instAction.Namespace = h.Namespace
+	instAction.ReleaseName = h.ReleaseName
+	instAction.DryRun = true
+	instAction.ClientOnly = true
+	instAction.Replace = true
+	instAction.IncludeCRDs = true
+	instAction.PostRenderer = h.PostRenderer
+	chart.Metadata.AppVersion = h.AppVersion
+
+	// Render the chart.
+	rel, err := instAction.Run(chart, values)
+	if err != nil {
+		return nil, fmt.Errorf("helm template failed: %s", err)
+	}
+
+	return rel, nil
+}
+
+// RenderedManifests returns the manifests and hooks of a rendered release as a single YAML stream, like helm template.
+func RenderedManifests(rel *release.Release) string {
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(rel.Manifest))
+	b.WriteString("\n")
+
+	for _, hook := range rel.Hooks {
+		fmt.Fprintf(&b, "---\n# Source: %s\n%s\n", hook.Path, strings.TrimSpace(hook.Manifest))
+	}
+
+	return b.String()
+}
+
+// Upgrade upgrades an existing release to the specified Helm chart with the given parameters, and returns the upgraded release or an error if one occurs.
+func (h *Helm) Upgrade(chart *chart.Chart, kubeContext string) (*release.Release, error) {
+	// Parse the values file.
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(h.ValuesFile), &values); err != nil {
+		return nil, fmt.Errorf("failed to parse values file: %w", err)
+	}
+
+	// Parse the set values and add them to the values map.
+	for _, v := range h.SetValues {
+		if err := strvals.ParseInto(v, values); err != nil {
+			return nil, fmt.Errorf("failed parsing --set data: %w", err)
+		}
+	}
+
+	// Initialize the Helm action configuration.
+	actionConfig, err := initialize(kubeContext, h.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// Configure the Helm upgrade options. The chart values are fully rebuilt from the setup data,
+	// so values from the previous revision must not be reused.
+	upgradeAction := action.NewUpgrade(actionConfig)
+	upgradeAction.Namespace = h.Namespace
+	upgradeAction.ResetValues = true
+	upgradeAction.PostRenderer = h.PostRenderer
+	upgradeAction.Wait = h.Wait
+	upgradeAction.Timeout = 300 * time.Second
+	chart.Metadata.AppVersion = h.AppVersion
 
+	// Upgrade the release.
+	fmt.Println("Upgrading using helm chart...")
+	rel, err := upgradeAction.Run(h.ReleaseName, chart, values)
+	if err != nil {
+		return nil, fmt.Errorf("helm upgrade failed: %s", err)
+	}
+
+	// Print the chart upgrade details.
+	fmt.Printf("Using chart version %q, upgraded %q to version %q in namespace %q (revision %d)\n",
+		rel.Chart.Metadata.Version, rel.Name, rel.Chart.Metadata.AppVersion, rel.Namespace, rel.Version)
+
+	return rel, nil
+}
+
+// UnInstall uninstalls the specified release from the specified Kubernetes context and namespace, using the Helm
+// storage driver it was installed with. A release that does not exist counts as uninstalled.
+func (h *Helm) UnInstall(kubeContext, releaseName, namespace, helmDriver string) error {
 	// Set up the Helm action configuration.
-	actionConfig := new(action.Configuration)
-	if err := actionConfig.Init(kubeConfig.RESTClientGetter(), kubeConfig.Namespace(), os.Getenv("HELM_DRIVER"), log.Printf); err != nil {
-		log.Fatalf("Failed to initialize Helm action configuration: %v", err)
+	actionConfig, err := initializeWithDriver(kubeContext, namespace, helmDriver)
+	if err != nil {
+		return err
 	}
 
 	if err := deleteHelmRelease(releaseName, actionConfig); err != nil {
-		log.Fatalf("Error deleting Helm release: %v", err)
+		if errors.Is(err, driver.ErrReleaseNotFound) {
+			fmt.Printf("Release %s already deleted\n", releaseName)
+			return nil
+		}
+		return fmt.Errorf("error deleting Helm release: %w", err)
 	}
 
 	return nil
@@ -196,6 +308,8 @@ func List(kubeContext string) ([]*release.Release, error) {
 	// Configure the Helm list options.
 	client := action.NewList(actionConfig)
 	client.AllNamespaces = true
+	client.All = true
+	client.SetStateMask()
 
 	// List all installed releases.
 	releases, err := client.Run()
@@ -207,11 +321,12 @@ func List(kubeContext string) ([]*release.Release, error) {
 }
 
 // Status returns the status of the specified release in the specified Kubernetes cluster.
-func Status(kubeContext, releaseName, namespace string) (string, error) {
+// The returned release includes the rendered manifest, but not the chart.
+func Status(kubeContext, releaseName, namespace string) (*release.Release, error) {
 	// Initialize the Helm action configuration.
 	actionConfig, err := initialize(kubeContext, namespace)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Configure the Helm status options.
@@ -220,13 +335,81 @@ func Status(kubeContext, releaseName, namespace string) (string, error) {
 	// Get the status of the specified release.
 	rel, err := status.Run(releaseName)
 	if (err) != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Strip chart metadata from the output.
 	rel.Chart = nil
 
-	return "", nil
+	return rel, nil
+}
+
+// GetRelease returns the latest revision of the specified release in the specified Kubernetes cluster and namespace.
+func GetRelease(kubeContext, releaseName, namespace string) (*release.Release, error) {
+	// Initialize the Helm action configuration.
+	actionConfig, err := initialize(kubeContext, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the specified release.
+	rel, err := action.NewGet(actionConfig).Run(releaseName)
+	if err != nil {
+		return nil, err
+	}
+
+	return rel, nil
+}
+
+// History returns the revisions of the specified release in the specified Kubernetes cluster and namespace, oldest first.
+func History(kubeContext, releaseName, namespace string) ([]*release.Release, error) {
+	// Initialize the Helm action configuration.
+	actionConfig, err := initialize(kubeContext, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// Configure the Helm history options.
+	history := action.NewHistory(actionConfig)
+
+	// Get the revisions of the specified release.
+	releases, err := history.Run(releaseName)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseutil.SortByRevision(releases)
+
+	return releases, nil
+}
+
+// Rollback rolls back the specified release to the given revision in the specified Kubernetes cluster and namespace.
+// Rolling back creates a new revision; the number of that revision is returned.
+func Rollback(kubeContext, releaseName, namespace string, revision int) (int, error) {
+	// Initialize the Helm action configuration.
+	actionConfig, err := initialize(kubeContext, namespace)
+	if err != nil {
+		return 0, err
+	}
+
+	// Configure the Helm rollback options.
+	rollback := action.NewRollback(actionConfig)
+	rollback.Version = revision
+	rollback.Timeout = 300 * time.Second
+
+	// Roll back the specified release.
+	fmt.Printf("Rolling back %q to revision %d...\n", releaseName, revision)
+	if err := rollback.Run(releaseName); err != nil {
+		return 0, fmt.Errorf("helm rollback failed: %w", err)
+	}
+
+	// Find the revision created by the rollback.
+	rel, err := actionConfig.Releases.Last(releaseName)
+	if err != nil {
+		return 0, err
+	}
+
+	return rel.Version, nil
 }
 
 // Uninstall uninstalls the specified release from the specified Kubernetes cluster and namespace.
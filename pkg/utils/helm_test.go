@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// chartValues returns the values set up for the setup data, decoded in the layout of the chart values.
+func chartValues(t *testing.T, setupData SetupData) ZincObserveValues {
+	t.Helper()
+
+	base := map[string]interface{}{
+		"serviceAccount": map[string]interface{}{"annotations": map[string]interface{}{}},
+	}
+	values, err := setUpChartValues(base, setupData)
+	if err != nil {
+		t.Fatalf("setUpChartValues() error = %v", err)
+	}
+
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ZincObserveValues
+	if err := yaml.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded
+}
+
+func TestSetUpChartValuesIdentity(t *testing.T) {
+	roleArn := "arn:aws:iam::123456789012:role/zo1"
+
+	irsa := chartValues(t, SetupData{ReleaseName: "zo1", K8s: "eks", AWSIdentity: AWSIdentityIRSA, BucketName: "b1", IamRole: roleArn})
+	if got := irsa.ServiceAccount.Annotations["eks.amazonaws.com/role-arn"]; got != roleArn {
+		t.Errorf("irsa role annotation = %q, want %q", got, roleArn)
+	}
+	if irsa.ServiceAccount.Name != "" {
+		t.Errorf("irsa service account name = %q, want the chart default", irsa.ServiceAccount.Name)
+	}
+
+	podIdentity := chartValues(t, SetupData{ReleaseName: "zo1", K8s: "eks", AWSIdentity: AWSIdentityPodIdentity, BucketName: "b1", IamRole: roleArn})
+	if got := podIdentity.ServiceAccount.Name; got != "zo1-zincobserve" {
+		t.Errorf("pod-identity service account name = %q, want zo1-zincobserve", got)
+	}
+	if got, ok := podIdentity.ServiceAccount.Annotations["eks.amazonaws.com/role-arn"]; ok {
+		t.Errorf("pod-identity role annotation = %q, want none", got)
+	}
+	if got := podIdentity.Config.ZOS3BUCKETNAME; got != "b1" {
+		t.Errorf("pod-identity bucket name = %q, want b1", got)
+	}
+}
+
+func TestSetUpChartValuesRegion(t *testing.T) {
+	tests := []struct {
+		name      string
+		setupData SetupData
+		want      string
+	}{
+		{"region of the installation", SetupData{K8s: "eks", Region: "eu-west-1"}, "eu-west-1"},
+		{"region of an adopted bucket", SetupData{K8s: "eks", Region: "eu-west-1", BucketRegion: "us-east-2"}, "us-east-2"},
+		{"gcs", SetupData{K8s: "gke", Region: "eu-west-1"}, "us-east-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chartValues(t, tt.setupData).Config.ZOS3REGIONNAME; got != tt.want {
+				t.Errorf("ZO_S3_REGION_NAME = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,18 +1,37 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
 	"context"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
-func GetS3PolicyDocument(bucketName string) string {
+// GetS3PolicyDocument returns the inline policy of the IAM role of an installation, granting access to the objects of
+// the bucket. When the bucket is encrypted with a KMS key, the use of that key is granted as well.
+func GetS3PolicyDocument(bucketName, kmsKeyArn string) string {
+	kmsStatement := ""
+	if kmsKeyArn != "" {
+		kmsStatement = fmt.Sprintf(`,
+				{
+					"Effect": "Allow",
+					"Action": [
+						"kms:Encrypt",
+						"kms:Decrypt",
+						"kms:GenerateDataKey"
+					],
+					"Resource": [
+						"%s"
+					]
+				}`, kmsKeyArn)
+	}
+
 	policy := fmt.Sprintf(`{
 			"Version": "2012-10-17",
 			"Statement": [
@@ -28,26 +47,60 @@ func GetS3PolicyDocument(bucketName string) string {
 						"arn:aws:s3:::%s",
 						"arn:aws:s3:::%s/*"
 					]
-				}
+				}%s
 			]
-		}`, bucketName, bucketName)
+		}`, bucketName, bucketName, kmsStatement)
 
 	return policy
 }
 
+// GetIAMTrustPolicyDocument returns the trust policy allowing the OIDC provider of an EKS cluster issuer to assume a role with a web identity.
+func GetIAMTrustPolicyDocument(accountId, issuer string) string {
+	trustedEntity := fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Principal": {
+				"Federated": "%s"
+			},
+			"Action": "sts:AssumeRoleWithWebIdentity"
+		}
+	]
+}`, OIDCProviderArn(accountId, issuer))
+
+	return trustedEntity
+}
+
+// GetPodIdentityTrustPolicyDocument returns the trust policy allowing EKS Pod Identity to assume a role for the pods of a cluster.
+func GetPodIdentityTrustPolicyDocument() string {
+	return `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Principal": {
+				"Service": "pods.eks.amazonaws.com"
+			},
+			"Action": [
+				"sts:AssumeRole",
+				"sts:TagSession"
+			]
+		}
+	]
+}`
+}
+
 // GetAWSAccountID retrieves the AWS account number for the current user.
 // It returns the account number string, or an error if one occurs.
 func GetAWSAccountID() (string, error) {
 
-	// Load the AWS configuration.
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	// Create a new STS client to interact with AWS Security Token Service (STS).
+	svc, err := newSTSClient()
 	if err != nil {
 		return "", err
 	}
 
-	// Create a new STS client to interact with AWS Security Token Service (STS).
-	svc := sts.NewFromConfig(cfg)
-
 	// Call the GetCallerIdentity API to retrieve the account number.
 	resp, err := svc.GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
 	if err != nil {
@@ -62,32 +115,22 @@ func GetAWSAccountID() (string, error) {
 
 // CreateIAMRole creates an IAM role with the EKS trusted entity and attaches an S3 bucket policy to it.
 // It returns the ARN of the created role, or an error if one occurs.
-func CreateIAMRole(accountId, region, issuerId, roleName, policyName, clusterName, releaseName, bucketName string) (string, error) {
+func CreateIAMRole(accountId, region, issuer, roleName, policyName, clusterName, releaseName, bucketName, kmsKeyArn string) (string, error) {
+	return CreateIAMRoleWithTrustPolicy(GetIAMTrustPolicyDocument(accountId, issuer), roleName, region, policyName, bucketName, kmsKeyArn)
+}
+
+// CreateIAMRoleWithTrustPolicy creates an IAM role with the specified trust policy and attaches an S3 bucket policy to it.
+// The policy grants the use of the KMS key of the bucket when kmsKeyArn is set.
+// It returns the ARN of the created role, or an error if one occurs.
+func CreateIAMRoleWithTrustPolicy(trustedEntity, roleName, region, policyName, bucketName, kmsKeyArn string) (string, error) {
 	fmt.Println("Creating IAM role...")
 
-	// Load the AWS configuration.
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	// Create a new IAM client.
+	svc, err := newIAMClient(region)
 	if err != nil {
 		return "", err
 	}
 
-	// Create a new IAM client.
-	svc := iam.NewFromConfig(cfg)
-
-	// Define the trusted entity for the role.
-	trustedEntity := fmt.Sprintf(`{
-	"Version": "2012-10-17",
-	"Statement": [
-		{
-			"Effect": "Allow",
-			"Principal": {
-				"Federated": "arn:aws:iam::%s:oidc-provider/oidc.eks.%s.amazonaws.com/id/%s"
-			},
-			"Action": "sts:AssumeRoleWithWebIdentity"
-		}
-	]
-}`, accountId, region, issuerId)
-
 	// Create the input for creating the role.
 	input := &iam.CreateRoleInput{
 		RoleName:                 aws.String(roleName),
@@ -106,7 +149,7 @@ func CreateIAMRole(accountId, region, issuerId, roleName, policyName, clusterNam
 	fmt.Println("Creating inline policy for IAM role............")
 
 	// Create a policy document for the S3 bucket policy.
-	policyDocument := GetS3PolicyDocument(bucketName)
+	policyDocument := GetS3PolicyDocument(bucketName, kmsKeyArn)
 
 	// Attach the policy to the role.
 	_, err = svc.PutRolePolicy(context.Background(), &iam.PutRolePolicyInput{
@@ -125,22 +168,19 @@ func CreateIAMRole(accountId, region, issuerId, roleName, policyName, clusterNam
 
 // DeleteIAMRoleWithPolicies deletes an IAM role and all of its associated policies.
 // It returns an error if one occurs.
-func DeleteIAMRoleWithPolicies(roleArn string) error {
+func DeleteIAMRoleWithPolicies(roleArn, region string) error {
 	fmt.Println("DeleteIAMRoleWithPolicies............")
 
-	roleName := strings.Split(roleArn, "/")[1] // Extract the role name from the ARN.
+	roleName := roleArn[strings.LastIndex(roleArn, "/")+1:] // Extract the role name from the ARN.
 
-	// Load the AWS configuration.
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	// Create a new IAM client.
+	svc, err := newIAMClient(region)
 	if err != nil {
 		return err
 	}
 
-	// Create a new IAM client.
-	svc := iam.NewFromConfig(cfg)
-
 	// Delete any inline policies attached to the role.
-	err = deleteInlineRolePolicies(roleName)
+	err = deleteInlineRolePolicies(roleName, region)
 	if err != nil {
 		return err
 	}
@@ -159,26 +199,28 @@ func DeleteIAMRoleWithPolicies(roleArn string) error {
 
 // deleteInlineRolePolicies deletes all inline policies attached to an IAM role.
 // It returns an error if one occurs.
-func deleteInlineRolePolicies(roleName string) error {
-	// Load the AWS SDK config.
-	cfg, err := config.LoadDefaultConfig(context.Background())
+func deleteInlineRolePolicies(roleName, region string) error {
+	// Create a new IAM client.
+	svc, err := newIAMClient(region)
 	if err != nil {
 		return err
 	}
 
-	// Create a new IAM client.
-	svc := iam.NewFromConfig(cfg)
-
-	// List the inline policies attached to the role.
-	resp, err := svc.ListRolePolicies(context.TODO(), &iam.ListRolePoliciesInput{
+	// List the inline policies attached to the role, before deleting any so that no page is skipped.
+	policyNames := []string{}
+	pages := iam.NewListRolePoliciesPaginator(svc, &iam.ListRolePoliciesInput{
 		RoleName: &roleName,
 	})
-	if err != nil {
-		return err
+	for pages.HasMorePages() {
+		resp, err := pages.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+		policyNames = append(policyNames, resp.PolicyNames...)
 	}
 
 	// Delete each inline policy attached to the role.
-	for _, policyName := range resp.PolicyNames {
+	for _, policyName := range policyNames {
 		_, err := svc.DeleteRolePolicy(context.TODO(), &iam.DeleteRolePolicyInput{
 			RoleName:   &roleName,
 			PolicyName: aws.String(policyName),
@@ -191,3 +233,138 @@ func deleteInlineRolePolicies(roleName string) error {
 
 	return nil
 }
+
+// IAMRoleExists checks whether the IAM role with the specified ARN exists.
+func IAMRoleExists(roleArn, region string) (bool, error) {
+	roleName := roleArn[strings.LastIndex(roleArn, "/")+1:] // Extract the role name from the ARN.
+
+	// Create a new IAM client.
+	svc, err := newIAMClient(region)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = svc.GetRole(context.Background(), &iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil {
+		var notFound *types.NoSuchEntityException
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetIAMRoleArn returns the ARN of the IAM role given by name or ARN, as reported by IAM with its path and partition.
+func GetIAMRoleArn(role, region string) (string, error) {
+	roleName := role[strings.LastIndex(role, "/")+1:] // Extract the role name from the ARN.
+
+	// Create a new IAM client.
+	svc, err := newIAMClient(region)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := svc.GetRole(context.Background(), &iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(resp.Role.Arn), nil
+}
+
+// GetIAMRoleTrustPolicy returns the trust policy document of the IAM role with the specified ARN.
+func GetIAMRoleTrustPolicy(roleArn, region string) (string, error) {
+	roleName := roleArn[strings.LastIndex(roleArn, "/")+1:] // Extract the role name from the ARN.
+
+	// Create a new IAM client.
+	svc, err := newIAMClient(region)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := svc.GetRole(context.Background(), &iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(resp.Role.AssumeRolePolicyDocument), nil
+}
+
+// UpdateIAMRoleTrustPolicy replaces the trust policy document of the IAM role with the specified ARN.
+func UpdateIAMRoleTrustPolicy(roleArn, region, policyDocument string) error {
+	roleName := roleArn[strings.LastIndex(roleArn, "/")+1:] // Extract the role name from the ARN.
+
+	// Create a new IAM client.
+	svc, err := newIAMClient(region)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.UpdateAssumeRolePolicy(context.Background(), &iam.UpdateAssumeRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyDocument: aws.String(policyDocument),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Updated the trust policy of IAM role %s\n", roleName)
+	return nil
+}
+
+// GetIAMRoleInlinePolicy returns the document of an inline policy of the IAM role with the specified ARN.
+// It returns false when the role has no inline policy with that name.
+func GetIAMRoleInlinePolicy(roleArn, region, policyName string) (string, bool, error) {
+	roleName := roleArn[strings.LastIndex(roleArn, "/")+1:] // Extract the role name from the ARN.
+
+	// Create a new IAM client.
+	svc, err := newIAMClient(region)
+	if err != nil {
+		return "", false, err
+	}
+
+	resp, err := svc.GetRolePolicy(context.Background(), &iam.GetRolePolicyInput{
+		RoleName:   aws.String(roleName),
+		PolicyName: aws.String(policyName),
+	})
+	if err != nil {
+		var notFound *types.NoSuchEntityException
+		if errors.As(err, &notFound) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	return aws.ToString(resp.PolicyDocument), true, nil
+}
+
+// PutIAMRoleInlinePolicy creates or replaces an inline policy of the IAM role with the specified ARN.
+func PutIAMRoleInlinePolicy(roleArn, region, policyName, policyDocument string) error {
+	roleName := roleArn[strings.LastIndex(roleArn, "/")+1:] // Extract the role name from the ARN.
+
+	// Create a new IAM client.
+	svc, err := newIAMClient(region)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.PutRolePolicy(context.Background(), &iam.PutRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(policyDocument),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Put inline policy %s on IAM role %s\n", policyName, roleName)
+	return nil
+}
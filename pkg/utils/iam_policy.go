@@ -0,0 +1,325 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// policyDocument is an IAM policy document, either a trust policy or a permissions policy.
+type policyDocument struct {
+	Version   string           `json:"Version"`
+	Statement policyStatements `json:"Statement"`
+}
+
+// policyStatement is a statement of an IAM policy document. Conditions are not evaluated.
+type policyStatement struct {
+	Effect    string          `json:"Effect"`
+	Principal json.RawMessage `json:"Principal,omitempty"`
+	Action    stringOrSlice   `json:"Action"`
+	Resource  stringOrSlice   `json:"Resource"`
+}
+
+// policyStatements holds the statements of a policy document, which can be either a single statement or a list.
+type policyStatements []policyStatement
+
+// UnmarshalJSON accepts both a statement and a list of statements.
+func (s *policyStatements) UnmarshalJSON(b []byte) error {
+	var single policyStatement
+	if err := json.Unmarshal(b, &single); err == nil {
+		*s = []policyStatement{single}
+		return nil
+	}
+
+	var list []policyStatement
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*s = list
+
+	return nil
+}
+
+// stringOrSlice holds the policy elements that can be either a single string or a list of strings.
+type stringOrSlice []string
+
+// UnmarshalJSON accepts both a string and a list of strings.
+func (s *stringOrSlice) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*s = []string{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*s = list
+
+	return nil
+}
+
+// parsePolicyDocument parses a policy document as returned by IAM, which URL encodes them.
+func parsePolicyDocument(document string) (policyDocument, error) {
+	policy := policyDocument{}
+
+	decoded, err := url.PathUnescape(document)
+	if err != nil {
+		decoded = document
+	}
+
+	err = json.Unmarshal([]byte(decoded), &policy)
+	if err != nil {
+		return policy, fmt.Errorf("failed to parse policy document: %v", err)
+	}
+
+	return policy, nil
+}
+
+// SamePolicyDocument reports whether two policy documents are equivalent, ignoring formatting, URL encoding and
+// whether single values are written as a string or a list of one string.
+func SamePolicyDocument(a, b string) (bool, error) {
+	normalized := []interface{}{}
+	for _, document := range []string{a, b} {
+		decoded, err := url.PathUnescape(document)
+		if err != nil {
+			decoded = document
+		}
+
+		var value interface{}
+		if err := json.Unmarshal([]byte(decoded), &value); err != nil {
+			return false, fmt.Errorf("failed to parse policy document: %v", err)
+		}
+		normalized = append(normalized, normalizePolicyValue(value))
+	}
+
+	return reflect.DeepEqual(normalized[0], normalized[1]), nil
+}
+
+// normalizePolicyValue replaces the lists of one element of a parsed policy document by their element.
+func normalizePolicyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, element := range v {
+			v[key] = normalizePolicyValue(element)
+		}
+		return v
+	case []interface{}:
+		if len(v) == 1 {
+			return normalizePolicyValue(v[0])
+		}
+		for i, element := range v {
+			v[i] = normalizePolicyValue(element)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// federatedPrincipals returns the federated principals of a trust policy statement.
+func (s policyStatement) federatedPrincipals() []string {
+	principal := map[string]stringOrSlice{}
+	if err := json.Unmarshal(s.Principal, &principal); err != nil {
+		return nil
+	}
+
+	return principal["Federated"]
+}
+
+// servicePrincipals returns the service principals of a trust policy statement.
+func (s policyStatement) servicePrincipals() []string {
+	principal := map[string]stringOrSlice{}
+	if err := json.Unmarshal(s.Principal, &principal); err != nil {
+		return nil
+	}
+
+	return principal["Service"]
+}
+
+// allows reports whether an Allow statement of the policy grants the action on the resource.
+func (p policyDocument) allows(action, resource string) bool {
+	for _, statement := range p.Statement {
+		if statement.Effect != "Allow" {
+			continue
+		}
+		if matchesAny(statement.Action, action, true) && matchesAny(statement.Resource, resource, false) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesAny reports whether the value matches one of the IAM wildcard patterns. Actions are matched case insensitively.
+func matchesAny(patterns []string, value string, ignoreCase bool) bool {
+	for _, pattern := range patterns {
+		expr := "^" + strings.ReplaceAll(strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*"), `\?`, ".") + "$"
+		if ignoreCase {
+			expr = "(?i)" + expr
+		}
+		if matched, _ := regexp.MatchString(expr, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VerifyAdoptedIAMRole checks that an existing IAM role can be used by an installation: its trust policy must allow
+// the OIDC provider of the cluster issuer to assume it with a web identity (irsa), or EKS Pod Identity to assume it
+// (pod-identity), and its inline and attached policies must grant everything GetS3PolicyDocument grants on the bucket
+// and, when kmsKeyArn is set, on the KMS key of the bucket.
+func VerifyAdoptedIAMRole(roleArn, region, awsIdentity, accountId, issuer, bucketName, kmsKeyArn string) error {
+	roleName := roleArn[strings.LastIndex(roleArn, "/")+1:] // Extract the role name from the ARN.
+
+	// Create a new IAM client.
+	svc, err := newIAMClient(region)
+	if err != nil {
+		return err
+	}
+
+	role, err := svc.GetRole(context.Background(), &iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get IAM role %s: %v", roleName, err)
+	}
+
+	// Check the trust policy
+	trustPolicy, err := parsePolicyDocument(aws.ToString(role.Role.AssumeRolePolicyDocument))
+	if err != nil {
+		return err
+	}
+
+	if awsIdentity == AWSIdentityPodIdentity {
+		trusted := false
+		for _, statement := range trustPolicy.Statement {
+			if statement.Effect != "Allow" || !matchesAny(statement.Action, "sts:AssumeRole", true) || !matchesAny(statement.Action, "sts:TagSession", true) {
+				continue
+			}
+			for _, principal := range statement.servicePrincipals() {
+				if principal == "pods.eks.amazonaws.com" {
+					trusted = true
+				}
+			}
+		}
+		if !trusted {
+			return fmt.Errorf("the trust policy of IAM role %s does not allow pods.eks.amazonaws.com to assume it with sts:AssumeRole and sts:TagSession", roleName)
+		}
+	} else {
+		provider := OIDCProviderArn(accountId, issuer)
+		trusted := false
+		for _, statement := range trustPolicy.Statement {
+			if statement.Effect != "Allow" || !matchesAny(statement.Action, "sts:AssumeRoleWithWebIdentity", true) {
+				continue
+			}
+			for _, principal := range statement.federatedPrincipals() {
+				if principal == provider {
+					trusted = true
+				}
+			}
+		}
+		if !trusted {
+			return fmt.Errorf("the trust policy of IAM role %s does not allow %s to assume it with sts:AssumeRoleWithWebIdentity", roleName, provider)
+		}
+	}
+
+	// Collect the inline and attached policies of the role
+	policies := []policyDocument{}
+
+	inlinePages := iam.NewListRolePoliciesPaginator(svc, &iam.ListRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	})
+	for inlinePages.HasMorePages() {
+		inline, err := inlinePages.NextPage(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, policyName := range inline.PolicyNames {
+			resp, err := svc.GetRolePolicy(context.Background(), &iam.GetRolePolicyInput{
+				RoleName:   aws.String(roleName),
+				PolicyName: aws.String(policyName),
+			})
+			if err != nil {
+				return err
+			}
+			policy, err := parsePolicyDocument(aws.ToString(resp.PolicyDocument))
+			if err != nil {
+				return err
+			}
+			policies = append(policies, policy)
+		}
+	}
+
+	attachedPages := iam.NewListAttachedRolePoliciesPaginator(svc, &iam.ListAttachedRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	})
+	for attachedPages.HasMorePages() {
+		attached, err := attachedPages.NextPage(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, attachedPolicy := range attached.AttachedPolicies {
+			policyResp, err := svc.GetPolicy(context.Background(), &iam.GetPolicyInput{
+				PolicyArn: attachedPolicy.PolicyArn,
+			})
+			if err != nil {
+				return err
+			}
+			versionResp, err := svc.GetPolicyVersion(context.Background(), &iam.GetPolicyVersionInput{
+				PolicyArn: attachedPolicy.PolicyArn,
+				VersionId: policyResp.Policy.DefaultVersionId,
+			})
+			if err != nil {
+				return err
+			}
+			policy, err := parsePolicyDocument(aws.ToString(versionResp.PolicyVersion.Document))
+			if err != nil {
+				return err
+			}
+			policies = append(policies, policy)
+		}
+	}
+
+	// Every action granted by GetS3PolicyDocument must be granted on the bucket, objects or key it applies to
+	required, err := parsePolicyDocument(GetS3PolicyDocument(bucketName, kmsKeyArn))
+	if err != nil {
+		return err
+	}
+
+	missing := []string{}
+	for _, statement := range required.Statement {
+		for _, action := range statement.Action {
+			for _, resource := range statement.Resource {
+				// Bucket actions apply to the bucket ARN and object actions to the object ARNs, the other pairs are not required
+				if (action == "s3:ListBucket") == strings.Contains(resource, "/") {
+					continue
+				}
+
+				granted := false
+				for _, policy := range policies {
+					granted = granted || policy.allows(action, resource)
+				}
+				if !granted {
+					missing = append(missing, action+" on "+resource)
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the policies of IAM role %s do not grant %s", roleName, strings.Join(missing, ", "))
+	}
+
+	fmt.Printf("IAM role %s can be used with bucket %s\n", roleName, bucketName)
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestSamePolicyDocument(t *testing.T) {
+	policy := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["s3:GetObject"],"Resource":"arn:aws:s3:::b1/*"}]}`
+
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{
+			name: "identical",
+			a:    policy,
+			b:    policy,
+			want: true,
+		},
+		{
+			name: "formatting and single element lists",
+			a:    policy,
+			b: `{
+				"Version": "2012-10-17",
+				"Statement": {"Effect": "Allow", "Action": "s3:GetObject", "Resource": ["arn:aws:s3:::b1/*"]}
+			}`,
+			want: true,
+		},
+		{
+			name: "URL encoded",
+			a:    policy,
+			b:    url.PathEscape(policy),
+			want: true,
+		},
+		{
+			name: "different resource",
+			a:    policy,
+			b:    `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"arn:aws:s3:::b2/*"}]}`,
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SamePolicyDocument(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("SamePolicyDocument() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("SamePolicyDocument() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := SamePolicyDocument(policy, "{"); err == nil {
+		t.Error("SamePolicyDocument() with an invalid document, want an error")
+	}
+}
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		name       string
+		patterns   []string
+		value      string
+		ignoreCase bool
+		want       bool
+	}{
+		{"exact", []string{"s3:GetObject"}, "s3:GetObject", false, true},
+		{"wildcard", []string{"s3:*"}, "s3:PutObject", false, true},
+		{"single character wildcard", []string{"s3:?etObject"}, "s3:GetObject", false, true},
+		{"case insensitive", []string{"S3:getobject"}, "s3:GetObject", true, true},
+		{"case sensitive", []string{"arn:aws:s3:::B1"}, "arn:aws:s3:::b1", false, false},
+		{"regexp characters are literal", []string{"arn:aws:s3:::b.1"}, "arn:aws:s3:::bx1", false, false},
+		{"second pattern", []string{"s3:ListBucket", "arn:aws:s3:::b1/*"}, "arn:aws:s3:::b1/key", false, true},
+		{"no pattern", nil, "s3:GetObject", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesAny(tt.patterns, tt.value, tt.ignoreCase); got != tt.want {
+				t.Errorf("matchesAny(%v, %s) = %v, want %v", tt.patterns, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePolicyDocument(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		action   string
+		resource string
+		want     bool
+		wantErr  bool
+	}{
+		{
+			name:     "generated S3 policy grants the bucket",
+			document: GetS3PolicyDocument("b1", ""),
+			action:   "s3:PutObject",
+			resource: "arn:aws:s3:::b1/key",
+			want:     true,
+		},
+		{
+			name:     "generated S3 policy does not grant another bucket",
+			document: GetS3PolicyDocument("b1", ""),
+			action:   "s3:PutObject",
+			resource: "arn:aws:s3:::b2/key",
+			want:     false,
+		},
+		{
+			name:     "generated S3 policy grants the KMS key",
+			document: GetS3PolicyDocument("b1", "arn:aws:kms:us-east-1:123456789012:key/k1"),
+			action:   "kms:GenerateDataKey",
+			resource: "arn:aws:kms:us-east-1:123456789012:key/k1",
+			want:     true,
+		},
+		{
+			name:     "URL encoded",
+			document: url.PathEscape(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:*","Resource":"*"}]}`),
+			action:   "s3:GetObject",
+			resource: "arn:aws:s3:::b1/key",
+			want:     true,
+		},
+		{
+			name:     "single statement",
+			document: `{"Version":"2012-10-17","Statement":{"Effect":"Allow","Action":"s3:*","Resource":"arn:aws:s3:::b1/*"}}`,
+			action:   "s3:GetObject",
+			resource: "arn:aws:s3:::b1/key",
+			want:     true,
+		},
+		{
+			name:     "deny is not a grant",
+			document: `{"Version":"2012-10-17","Statement":[{"Effect":"Deny","Action":"s3:*","Resource":"*"}]}`,
+			action:   "s3:GetObject",
+			resource: "arn:aws:s3:::b1/key",
+			want:     false,
+		},
+		{
+			name:     "invalid document",
+			document: `{"Statement":`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := parsePolicyDocument(tt.document)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("parsePolicyDocument() want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePolicyDocument() error = %v", err)
+			}
+			if got := policy.allows(tt.action, tt.resource); got != tt.want {
+				t.Errorf("allows(%s, %s) = %v, want %v", tt.action, tt.resource, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package utils
+
+import "sort"
+
+// Installation summarizes a ZincObserve installation managed by zctl, joining its setup state with its Helm release.
+type Installation struct {
+	Name            string `json:"name" yaml:"name"`
+	Namespace       string `json:"namespace" yaml:"namespace"`
+	K8s             string `json:"k8s" yaml:"k8s"`
+	StorageProvider string `json:"storage_provider" yaml:"storage_provider"`
+	BucketName      string `json:"bucket_name" yaml:"bucket_name"`
+	ChartVersion    string `json:"chart_version" yaml:"chart_version"`
+	AppVersion      string `json:"app_version" yaml:"app_version"`
+	Revision        int    `json:"revision" yaml:"revision"`
+	Status          string `json:"status" yaml:"status"`
+}
+
+// ListInstallations returns every installation managed by zctl in all namespaces of the current kube context.
+// Installations whose Helm release is missing are reported with the status "release-missing".
+// The setup state objects that cannot be read are reported by the returned list of errors, see ListSetupData.
+func ListInstallations() ([]Installation, []error, error) {
+	setupDataList, readErrs, err := ListSetupData("")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	context, err := CurrentKubeContext()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	releases, err := List(context)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	installations := []Installation{}
+	for _, setupData := range setupDataList {
+		installation := Installation{
+			Name:            setupData.ReleaseName,
+			Namespace:       setupData.Namespace,
+			K8s:             setupData.K8s,
+			StorageProvider: StorageProvider(setupData),
+			BucketName:      setupData.BucketName,
+			ChartVersion:    setupData.ChartVersion,
+			Status:          "release-missing",
+		}
+
+		for _, rel := range releases {
+			if rel.Name == setupData.ReleaseName && rel.Namespace == setupData.Namespace {
+				installation.ChartVersion = rel.Chart.Metadata.Version
+				installation.AppVersion = rel.Chart.Metadata.AppVersion
+				installation.Revision = rel.Version
+				installation.Status = rel.Info.Status.String()
+				break
+			}
+		}
+
+		installations = append(installations, installation)
+	}
+
+	sort.Slice(installations, func(i, j int) bool {
+		if installations[i].Namespace != installations[j].Namespace {
+			return installations[i].Namespace < installations[j].Namespace
+		}
+		return installations[i].Name < installations[j].Name
+	})
+
+	return installations, readErrs, nil
+}
+
+// StorageProvider returns the object storage provider used by an installation.
+func StorageProvider(setupData SetupData) string {
+	switch {
+	case setupData.K8s == "eks":
+		return "s3"
+	case setupData.K8s == "gke":
+		return "gcs"
+	case setupData.InstallMinIO:
+		return "minio"
+	default:
+		return setupData.StorageProvider
+	}
+}
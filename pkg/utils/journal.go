@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of the resources recorded in the install journal.
+const (
+	ResourceS3Bucket               = "s3-bucket"
+	ResourceIAMRole                = "iam-role"
+	ResourceGCSBucket              = "gcs-bucket"
+	ResourceGCPServiceAccount      = "gcp-service-account"
+	ResourceHMACKey                = "hmac-key"
+	ResourceHelmRelease            = "helm-release"
+	ResourcePodIdentityAssociation = "pod-identity-association"
+	ResourceKMSKey                 = "kms-key"
+	ResourceIAMOIDCProvider        = "iam-oidc-provider" // shared by the cluster, planned but never journaled nor deleted
+)
+
+// Phases of an installation recorded in the setup data.
+const (
+	SetupPhaseInstalling = "installing"
+	SetupPhaseFailed     = "failed"
+	SetupPhaseInstalled  = "installed"
+)
+
+// JournalEntry records a resource created by an install.
+type JournalEntry struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Region string `json:"region,omitempty"`
+}
+
+// Journal records the resources created by an install in the order they were created,
+// so that they can be removed in reverse order when the install fails, and so that an interrupted
+// install can be resumed without creating them again.
+type Journal struct {
+	Entries []JournalEntry
+	// Checkpoint, when set, persists the progress of the install every time a resource is recorded.
+	Checkpoint func(setupData SetupData) error
+	// Remove, when set, deletes a recorded resource on rollback in place of removeJournalEntry.
+	Remove func(entry JournalEntry, setupData SetupData) error
+}
+
+// Record adds a created resource to the journal and checkpoints the setup data, which is expected to
+// already hold the created resource (e.g. its name or credentials).
+func (j *Journal) Record(setupData SetupData, kind, name, region string) error {
+	j.Entries = append(j.Entries, JournalEntry{Kind: kind, Name: name, Region: region})
+
+	if j.Checkpoint == nil {
+		return nil
+	}
+
+	setupData.Journal = j.Entries
+	err := j.Checkpoint(setupData)
+	if err != nil {
+		return fmt.Errorf("failed to record %s %s: %v", kind, name, err)
+	}
+
+	return nil
+}
+
+// Find returns the first recorded resource of the given kind.
+func (j *Journal) Find(kind string) (JournalEntry, bool) {
+	for _, entry := range j.Entries {
+		if entry.Kind == kind {
+			return entry, true
+		}
+	}
+
+	return JournalEntry{}, false
+}
+
+// Rollback removes the resources recorded in the journal in reverse order of creation.
+// Resources that are already gone count as removed. The entries that could not be removed are kept in the journal,
+// and an error listing them is returned. A KMS key is kept as long as the bucket it encrypts is.
+func (j *Journal) Rollback(setupData SetupData) error {
+	remaining := []JournalEntry{}
+	failures := []string{}
+	bucketKept := false
+	remove := j.Remove
+	if remove == nil {
+		remove = removeJournalEntry
+	}
+
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		entry := j.Entries[i]
+		fmt.Printf("Rolling back %s %s\n", entry.Kind, entry.Name)
+
+		var err error
+		if entry.Kind == ResourceKMSKey && bucketKept {
+			err = fmt.Errorf("the bucket encrypted with the key was not deleted")
+		} else {
+			err = remove(entry, setupData)
+		}
+		if err != nil && entry.Kind == ResourceS3Bucket {
+			bucketKept = true
+		}
+		if err != nil {
+			fmt.Printf("error rolling back %s %s: %v\n", entry.Kind, entry.Name, err)
+			remaining = append([]JournalEntry{entry}, remaining...)
+			failures = append(failures, fmt.Sprintf("%s %s: %v", entry.Kind, entry.Name, err))
+		}
+	}
+
+	j.Entries = remaining
+
+	if len(failures) > 0 {
+		return fmt.Errorf("could not roll back %d resource(s): %s", len(failures), strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// removeJournalEntry deletes the resource recorded by a journal entry.
+func removeJournalEntry(entry JournalEntry, setupData SetupData) error {
+	switch entry.Kind {
+	case ResourceS3Bucket:
+		exists, err := S3BucketExists(entry.Name, entry.Region)
+		if err != nil || !exists {
+			return err
+		}
+		return DeleteS3Bucket(entry.Name, entry.Region)
+	case ResourceIAMRole:
+		exists, err := IAMRoleExists(entry.Name, setupData.Region)
+		if err != nil || !exists {
+			return err
+		}
+		return DeleteIAMRoleWithPolicies(entry.Name, setupData.Region)
+	case ResourceGCSBucket:
+		exists, err := GCSBucketExists(entry.Name)
+		if err != nil || !exists {
+			return err
+		}
+		setupData.BucketName = entry.Name
+		return DeleteGCSBucket(setupData)
+	case ResourceGCPServiceAccount:
+		exists, err := GCPServiceAccountExists(setupData.GCPProjectId, entry.Name)
+		if err != nil || !exists {
+			return err
+		}
+		setupData.ServiceAccount = entry.Name
+		return DeleteGCPServiceAccount(setupData)
+	case ResourceHMACKey:
+		return DeleteHMACKey(setupData.GCPProjectId, entry.Name)
+	case ResourcePodIdentityAssociation:
+		return DeletePodIdentityAssociation(entry.Name)
+	case ResourceKMSKey:
+		return DeleteKMSKey(entry.Name, entry.Region)
+	case ResourceHelmRelease:
+		setupData.ReleaseName = entry.Name
+		return TearDownHelm(setupData)
+	default:
+		return fmt.Errorf("unknown resource kind %s", entry.Kind)
+	}
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJournalRollback(t *testing.T) {
+	bucket := JournalEntry{Kind: ResourceS3Bucket, Name: "b1", Region: "us-east-1"}
+	key := JournalEntry{Kind: ResourceKMSKey, Name: "k1", Region: "us-east-1"}
+	role := JournalEntry{Kind: ResourceIAMRole, Name: "arn:aws:iam::123456789012:role/zo1"}
+	release := JournalEntry{Kind: ResourceHelmRelease, Name: "zo1"}
+
+	tests := []struct {
+		name          string
+		entries       []JournalEntry
+		failing       string // kind of the entries that fail to be removed
+		wantRemoved   []JournalEntry
+		wantRemaining []JournalEntry
+		wantErr       string
+	}{
+		{
+			name:          "reverse order of creation",
+			entries:       []JournalEntry{bucket, role, release},
+			wantRemoved:   []JournalEntry{release, role, bucket},
+			wantRemaining: []JournalEntry{},
+		},
+		{
+			name:          "failed entries are kept",
+			entries:       []JournalEntry{bucket, role, release},
+			failing:       ResourceIAMRole,
+			wantRemoved:   []JournalEntry{release, role, bucket},
+			wantRemaining: []JournalEntry{role},
+			wantErr:       "could not roll back 1 resource(s): iam-role arn:aws:iam::123456789012:role/zo1: removal failed",
+		},
+		{
+			name:          "key removed after its bucket",
+			entries:       []JournalEntry{key, bucket, role},
+			wantRemoved:   []JournalEntry{role, bucket, key},
+			wantRemaining: []JournalEntry{},
+		},
+		{
+			name:          "key kept with its bucket",
+			entries:       []JournalEntry{key, bucket, role},
+			failing:       ResourceS3Bucket,
+			wantRemoved:   []JournalEntry{role, bucket},
+			wantRemaining: []JournalEntry{key, bucket},
+			wantErr:       "kms-key k1: the bucket encrypted with the key was not deleted",
+		},
+		{
+			name:          "empty journal",
+			entries:       []JournalEntry{},
+			wantRemoved:   []JournalEntry{},
+			wantRemaining: []JournalEntry{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			removed := []JournalEntry{}
+			journal := Journal{
+				Entries: tt.entries,
+				Remove: func(entry JournalEntry, setupData SetupData) error {
+					removed = append(removed, entry)
+					if entry.Kind == tt.failing {
+						return errors.New("removal failed")
+					}
+					return nil
+				},
+			}
+
+			err := journal.Rollback(SetupData{})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Rollback() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Rollback() error = %v, want %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("Rollback() removed %v, want %v", removed, tt.wantRemoved)
+			}
+			if !reflect.DeepEqual(journal.Entries, tt.wantRemaining) {
+				t.Errorf("Rollback() kept %v, want %v", journal.Entries, tt.wantRemaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// KMSKeyCreate is the value of --kms_key asking zctl to create a dedicated KMS key for the bucket.
+const KMSKeyCreate = "create"
+
+// kmsKeyDeletionWindowDays is the waiting period before a KMS key scheduled for deletion by zctl is deleted.
+// The deletion can be cancelled during that period.
+const kmsKeyDeletionWindowDays = 30
+
+// KMSKeyAlias returns the alias of the KMS key created by zctl for the bucket of an installation.
+func KMSKeyAlias(setupData SetupData) string {
+	return "alias/zinc-observe-" + setupData.Identifier + "-" + setupData.ClusterName + "-" + setupData.ReleaseName
+}
+
+// BucketKMSKey returns the ARN of the KMS key encrypting the bucket of an installation, or an empty string when the
+// bucket is not encrypted with SSE-KMS or its key is not created yet.
+func BucketKMSKey(setupData SetupData) string {
+	if setupData.BucketOptions == nil || setupData.BucketOptions.Encryption != BucketEncryptionSSEKMS {
+		return ""
+	}
+
+	return setupData.BucketOptions.KMSKeyID
+}
+
+// CreatedKMSKey returns the ARN of the KMS key created by zctl for the bucket of an installation, or an empty string
+// when the key was given for the install or no key is used.
+func CreatedKMSKey(setupData SetupData) string {
+	if setupData.BucketOptions == nil || !setupData.BucketOptions.CreateKMSKey {
+		return ""
+	}
+
+	return setupData.BucketOptions.KMSKeyID
+}
+
+// GetKMSKeyPolicyDocument returns the key policy of the KMS key created by zctl. It lets the account administer the
+// key and delegate its use to IAM policies; the IAM role of the installation is granted its use by GetS3PolicyDocument.
+func GetKMSKeyPolicyDocument(accountId string) string {
+	return fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Sid": "EnableIAMPolicies",
+			"Effect": "Allow",
+			"Principal": {
+				"AWS": "arn:aws:iam::%s:root"
+			},
+			"Action": "kms:*",
+			"Resource": "*"
+		}
+	]
+}`, accountId)
+}
+
+// CreateKMSKey creates a symmetric encryption KMS key with the specified key policy and tags, and names it with the alias.
+// It returns the ARN of the created key. The key is scheduled for deletion when the alias cannot be created.
+func CreateKMSKey(alias, region, keyPolicy string, tags map[string]string) (string, error) {
+	fmt.Println("Creating KMS key: ", alias)
+
+	// Create a new KMS client.
+	svc, err := newKMSClient(region)
+	if err != nil {
+		return "", err
+	}
+
+	keyTags := []types.Tag{}
+	for key, value := range tags {
+		keyTags = append(keyTags, types.Tag{TagKey: aws.String(key), TagValue: aws.String(value)})
+	}
+	sort.Slice(keyTags, func(i, j int) bool { return *keyTags[i].TagKey < *keyTags[j].TagKey })
+
+	resp, err := svc.CreateKey(context.Background(), &kms.CreateKeyInput{
+		Description: aws.String("Encryption of the ZincObserve bucket, created by zctl (" + alias + ")"),
+		KeySpec:     types.KeySpecSymmetricDefault,
+		KeyUsage:    types.KeyUsageTypeEncryptDecrypt,
+		Policy:      aws.String(keyPolicy),
+		Tags:        keyTags,
+	})
+	if err != nil {
+		return "", err
+	}
+	keyArn := aws.ToString(resp.KeyMetadata.Arn)
+
+	_, err = svc.CreateAlias(context.Background(), &kms.CreateAliasInput{
+		AliasName:   aws.String(alias),
+		TargetKeyId: aws.String(keyArn),
+	})
+	if err != nil {
+		if deleteErr := DeleteKMSKey(keyArn, region); deleteErr != nil {
+			return "", fmt.Errorf("%v; the created KMS key %s could not be deleted: %v", err, keyArn, deleteErr)
+		}
+		return "", err
+	}
+
+	fmt.Println("Created KMS key: ", keyArn)
+
+	return keyArn, nil
+}
+
+// KMSKeyUsable checks whether the KMS key with the specified ARN exists and is not pending deletion.
+// It returns the state of the key when it is not usable.
+func KMSKeyUsable(keyArn, region string) (bool, string, error) {
+	// Create a new KMS client.
+	svc, err := newKMSClient(region)
+	if err != nil {
+		return false, "", err
+	}
+
+	resp, err := svc.DescribeKey(context.Background(), &kms.DescribeKeyInput{
+		KeyId: aws.String(keyArn),
+	})
+	if err != nil {
+		var notFound *types.NotFoundException
+		if errors.As(err, &notFound) {
+			return false, "not found", nil
+		}
+		return false, "", err
+	}
+
+	state := resp.KeyMetadata.KeyState
+	if state == types.KeyStateEnabled {
+		return true, "", nil
+	}
+
+	return false, string(state), nil
+}
+
+// DeleteKMSKey deletes the aliases of the KMS key with the specified ARN and schedules the deletion of the key.
+// KMS keys are never deleted immediately: the deletion can be cancelled during the waiting period.
+// A key that does not exist or is already pending deletion counts as deleted.
+func DeleteKMSKey(keyArn, region string) error {
+	// Create a new KMS client.
+	svc, err := newKMSClient(region)
+	if err != nil {
+		return err
+	}
+
+	key, err := svc.DescribeKey(context.Background(), &kms.DescribeKeyInput{
+		KeyId: aws.String(keyArn),
+	})
+	if err != nil {
+		var notFound *types.NotFoundException
+		if errors.As(err, &notFound) {
+			fmt.Println("KMS key already deleted: ", keyArn)
+			return nil
+		}
+		return err
+	}
+	if key.KeyMetadata.KeyState == types.KeyStatePendingDeletion {
+		fmt.Println("KMS key already scheduled for deletion: ", keyArn)
+		return nil
+	}
+
+	aliases, err := svc.ListAliases(context.Background(), &kms.ListAliasesInput{
+		KeyId: aws.String(keyArn),
+	})
+	if err != nil {
+		return err
+	}
+	for _, alias := range aliases.Aliases {
+		_, err = svc.DeleteAlias(context.Background(), &kms.DeleteAliasInput{
+			AliasName: alias.AliasName,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("Deleted KMS alias: ", aws.ToString(alias.AliasName))
+	}
+
+	resp, err := svc.ScheduleKeyDeletion(context.Background(), &kms.ScheduleKeyDeletionInput{
+		KeyId:               aws.String(keyArn),
+		PendingWindowInDays: aws.Int32(kmsKeyDeletionWindowDays),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Scheduled the deletion of KMS key %s on %s\n", keyArn, aws.ToTime(resp.DeletionDate).Format("2006-01-02"))
+
+	return nil
+}
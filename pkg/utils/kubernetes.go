@@ -2,7 +2,6 @@ package utils
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 
 	"k8s.io/client-go/dynamic"
@@ -11,37 +10,68 @@ import (
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func CreateConfigMap(sData SetupData) error {
-	name := "zincobserve-setup"
+const (
+	// legacySetupConfigMapName is the name of the single setup ConfigMap used by older zctl versions.
+	legacySetupConfigMapName = "zincobserve-setup"
+
+	// ManagedByLabel and ManagedByValue mark the objects holding the zctl setup state.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "zctl"
+	// ReleaseLabel holds the name of the release a setup state object belongs to.
+	ReleaseLabel = "zctl.zinc.dev/release"
 
-	// var data = make(map[string]string)
+	// revisionSnapshots is the number of helm revisions whose setup data snapshot is kept in the ConfigMap,
+	// so that the ConfigMap stays well below the size limit of Kubernetes objects.
+	revisionSnapshots = 10
+)
 
-	dataBytes, err := json.Marshal(sData)
+// SetupConfigMapName returns the name of the ConfigMap holding the setup data of a release.
+func SetupConfigMapName(releaseName string) string {
+	return legacySetupConfigMapName + "-" + releaseName
+}
+
+// setupLabels returns the labels of the setup state objects of a release.
+func setupLabels(releaseName string) map[string]string {
+	return map[string]string{
+		ManagedByLabel: ManagedByValue,
+		ReleaseLabel:   releaseName,
+	}
+}
+
+// defaultClientset creates a Kubernetes clientset for the current kube context, loading the kubeconfig files like
+// kubectl does (KUBECONFIG or the default file).
+func defaultClientset() (*kubernetes.Clientset, error) {
+	return Client("")
+}
+
+// CreateConfigMap stores the setup data in a ConfigMap named and labeled after the release.
+// Credentials are kept out of the ConfigMap and stored in a Secret with the same name and labels.
+func CreateConfigMap(sData SetupData) error {
+	dataBytes, err := marshalSetupData(sData)
 	if err != nil {
 		return err
 	}
 
 	// convert the dataBytes to map[string]string
 	data := map[string]string{
-		"data": string(dataBytes),
+		"data": dataBytes,
 	}
 
-	// Use the default kubeconfig file to create a Config object.
-	kubeconfig, err := clientcmd.LoadFromFile(clientcmd.RecommendedHomeFile)
-	if err != nil {
-		return err
+	// keep a snapshot of the setup data for the helm revision it was deployed with
+	if sData.Revision > 0 {
+		data[revisionKey(sData.Revision)] = dataBytes
 	}
 
-	// create a configmap using the kubeconfig retrieved earlier
-	config, err := clientcmd.NewDefaultClientConfig(*kubeconfig, &clientcmd.ConfigOverrides{}).ClientConfig()
+	clientset, err := defaultClientset()
 	if err != nil {
 		return err
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	err = writeSetupSecret(clientset, sData)
 	if err != nil {
 		return err
 	}
@@ -49,8 +79,9 @@ func CreateConfigMap(sData SetupData) error {
 	// create the ConfigMap object
 	cm := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
+			Name:      SetupConfigMapName(sData.ReleaseName),
 			Namespace: sData.Namespace,
+			Labels:    setupLabels(sData.ReleaseName),
 		},
 		Data: data,
 	}
@@ -65,65 +96,450 @@ func CreateConfigMap(sData SetupData) error {
 	return nil
 }
 
-func ReadConfigMap(name string, namespace string) (SetupData, error) {
+// UpdateConfigMap overwrites the setup data stored in the ConfigMap and Secret of the release.
+// Snapshots recorded for the last revisionSnapshots helm revisions are kept, older ones are removed.
+func UpdateConfigMap(sData SetupData) error {
+	return updateSetupState(sData, true)
+}
+
+// updateCurrentSetupData overwrites the current setup data stored in the ConfigMap and Secret of the release, leaving
+// the revision snapshots untouched. It records state that was not deployed with a helm revision, e.g. by an uninstall.
+func updateCurrentSetupData(sData SetupData) error {
+	return updateSetupState(sData, false)
+}
+
+// updateSetupState overwrites the setup data of the release, and its snapshot for the helm revision when snapshot is set.
+func updateSetupState(sData SetupData, snapshot bool) error {
+	dataBytes, err := marshalSetupData(sData)
+	if err != nil {
+		return err
+	}
+
+	clientset, err := defaultClientset()
+	if err != nil {
+		return err
+	}
+
+	// Read the existing ConfigMap so that its metadata is preserved
+	cm, err := clientset.CoreV1().ConfigMaps(sData.Namespace).Get(context.Background(), SetupConfigMapName(sData.ReleaseName), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	err = writeSetupSecret(clientset, sData)
+	if err != nil {
+		return err
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data["data"] = dataBytes
+
+	// keep a snapshot of the setup data for the helm revision it was deployed with
+	if snapshot && sData.Revision > 0 {
+		cm.Data[revisionKey(sData.Revision)] = dataBytes
+		pruneRevisionSnapshots(cm.Data, sData.Revision)
+	}
+
+	// update the ConfigMap
+	_, err = clientset.CoreV1().ConfigMaps(sData.Namespace).Update(context.Background(), cm, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("ConfigMap updated successfully")
+	return nil
+}
+
+// ReadConfigMap reads the setup data of the given release from its ConfigMap, merged with the credentials from its Secret.
+// It refuses to return setup data recorded for a different release.
+func ReadConfigMap(releaseName string, namespace string) (SetupData, error) {
+	return readSetupData(releaseName, namespace, "data")
+}
+
+// ReadConfigMapRevision reads the snapshot of the setup data recorded for the given helm revision of a release,
+// merged with the credentials from its Secret.
+func ReadConfigMapRevision(releaseName string, namespace string, revision int) (SetupData, error) {
+	return readSetupData(releaseName, namespace, revisionKey(revision))
+}
 
+// ReadSetupState reads the setup data of the given release like ReadConfigMap, without writing to the cluster:
+// a ConfigMap written by an older zctl under the shared legacy name is read where it is, and credentials found in the
+// ConfigMap are used without being moved to the Secret. It only needs the right to read ConfigMaps and Secrets.
+func ReadSetupState(releaseName string, namespace string) (SetupData, error) {
 	setupData := SetupData{}
-	// Use the default kubeconfig file to create a Config object.
-	kubeconfig, err := clientcmd.LoadFromFile(clientcmd.RecommendedHomeFile)
+
+	clientset, err := defaultClientset()
+	if err != nil {
+		return setupData, err
+	}
+
+	cm, _, err := findSetupConfigMap(clientset, releaseName, namespace)
 	if err != nil {
 		return setupData, err
 	}
 
-	// create a configmap using the kubeconfig retrieved earlier
-	config, err := clientcmd.NewDefaultClientConfig(*kubeconfig, &clientcmd.ConfigOverrides{}).ClientConfig()
+	setupData, err = decodeReleaseSetupData(cm, releaseName, "data")
 	if err != nil {
 		return setupData, err
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	if setupData.S3AccessKey == "" && setupData.S3SecretKey == "" {
+		err = mergeSetupSecret(clientset, &setupData)
+		if err != nil {
+			return setupData, err
+		}
+	}
+
+	return withHMACAccessID(setupData), nil
+}
+
+// readSetupData reads the setup data stored under the given key of the ConfigMap of a release and merges the credentials from its Secret.
+// Credentials found in the current setup data of the ConfigMap, as written by older zctl versions, are moved to the Secret.
+func readSetupData(releaseName string, namespace string, key string) (SetupData, error) {
+	setupData := SetupData{}
+
+	clientset, err := defaultClientset()
 	if err != nil {
 		return setupData, err
 	}
 
-	// Read the ConfigMap
-	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	cm, err := getSetupConfigMap(clientset, releaseName, namespace)
 	if err != nil {
 		return setupData, err
 	}
 
-	// marshal the configma data object into setupData
-	err = json.Unmarshal([]byte(cm.Data["data"]), &setupData)
+	setupData, err = decodeReleaseSetupData(cm, releaseName, key)
 	if err != nil {
 		return setupData, err
 	}
 
+	// Only the current setup data moves its credentials to the Secret: the credentials of a revision snapshot may be
+	// stale, and the current ones from the Secret are used instead.
+	if key == "data" && (setupData.S3AccessKey != "" || setupData.S3SecretKey != "") {
+		fmt.Printf("Moving credentials of release %s from configmap %s to secret %s\n", releaseName, cm.Name, cm.Name)
+		err = rewriteSetupState(clientset, cm, setupData)
+		if err != nil {
+			return setupData, err
+		}
+	} else {
+		err = mergeSetupSecret(clientset, &setupData)
+		if err != nil {
+			return setupData, err
+		}
+	}
+
+	return withHMACAccessID(setupData), nil
+}
+
+// decodeReleaseSetupData decodes the setup data stored under the given key of the ConfigMap of a release.
+// It refuses setup data recorded for a different release.
+func decodeReleaseSetupData(cm *corev1.ConfigMap, releaseName string, key string) (SetupData, error) {
+	value, ok := cm.Data[key]
+	if !ok {
+		if key == "data" {
+			return SetupData{}, fmt.Errorf("no setup data found in configmap %s/%s", cm.Namespace, cm.Name)
+		}
+		return SetupData{}, fmt.Errorf("no setup data recorded for %s", key)
+	}
+
+	// decode the configmap data object into setupData, upgrading older schema versions
+	setupData, err := decodeSetupData(value)
+	if err != nil {
+		return setupData, err
+	}
+
+	if setupData.ReleaseName != releaseName {
+		return setupData, fmt.Errorf("setup data in configmap %s/%s belongs to release %q, not %q", cm.Namespace, cm.Name, setupData.ReleaseName, releaseName)
+	}
+
 	return setupData, nil
 }
 
-func DeleteConfigMap(name string, namespace string) error {
-	// Use the default kubeconfig file to create a Config object.
-	kubeconfig, err := clientcmd.LoadFromFile(clientcmd.RecommendedHomeFile)
+// withHMACAccessID sets the access ID of the HMAC key of GKE installs made before it was recorded, which is the
+// access key of the credentials.
+func withHMACAccessID(setupData SetupData) SetupData {
+	if setupData.K8s == "gke" && setupData.HMACAccessID == "" {
+		setupData.HMACAccessID = setupData.S3AccessKey
+	}
+
+	return setupData
+}
+
+// mergeSetupSecret sets the credentials of the setup data from the Secret of the release.
+func mergeSetupSecret(clientset *kubernetes.Clientset, sData *SetupData) error {
+	secret, err := clientset.CoreV1().Secrets(sData.Namespace).Get(context.Background(), SetupConfigMapName(sData.ReleaseName), metav1.GetOptions{})
 	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// installs without credentials (e.g. EKS with IRSA) may not have a Secret
+			return nil
+		}
 		return err
 	}
 
-	// create a configmap using the kubeconfig retrieved earlier
-	config, err := clientcmd.NewDefaultClientConfig(*kubeconfig, &clientcmd.ConfigOverrides{}).ClientConfig()
+	sData.S3AccessKey = string(secret.Data["s3_access_key"])
+	sData.S3SecretKey = string(secret.Data["s3_secret_key"])
+
+	return nil
+}
+
+// writeSetupSecret creates or updates the Secret holding the credentials of a release.
+func writeSetupSecret(clientset *kubernetes.Clientset, sData SetupData) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      SetupConfigMapName(sData.ReleaseName),
+			Namespace: sData.Namespace,
+			Labels:    setupLabels(sData.ReleaseName),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"s3_access_key": []byte(sData.S3AccessKey),
+			"s3_secret_key": []byte(sData.S3SecretKey),
+		},
+	}
+
+	existing, err := clientset.CoreV1().Secrets(sData.Namespace).Get(context.Background(), secret.Name, metav1.GetOptions{})
 	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		_, err = clientset.CoreV1().Secrets(sData.Namespace).Create(context.Background(), secret, metav1.CreateOptions{})
 		return err
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	existing.Data = secret.Data
+	_, err = clientset.CoreV1().Secrets(sData.Namespace).Update(context.Background(), existing, metav1.UpdateOptions{})
+	return err
+}
+
+// rewriteSetupState writes the credentials of the setup data to the Secret of the release, and rewrites the current
+// setup data and every revision snapshot in the ConfigMap in the current schema version, without credentials.
+func rewriteSetupState(clientset *kubernetes.Clientset, cm *corev1.ConfigMap, sData SetupData) error {
+	err := writeSetupSecret(clientset, sData)
 	if err != nil {
 		return err
 	}
 
-	// Delete the ConfigMap
-	err = clientset.CoreV1().ConfigMaps(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+	for key, value := range cm.Data {
+		snapshot, err := decodeSetupData(value)
+		if err != nil {
+			return err
+		}
+
+		cm.Data[key], err = marshalSetupData(snapshot)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = clientset.CoreV1().ConfigMaps(cm.Namespace).Update(context.Background(), cm, metav1.UpdateOptions{})
+	return err
+}
+
+// MigrateState rewrites the setup state of a release in the current schema version, moving any credentials
+// from the ConfigMap to the Secret. It returns the schema version the setup data was stored in.
+func MigrateState(releaseName string, namespace string) (string, error) {
+	clientset, err := defaultClientset()
+	if err != nil {
+		return "", err
+	}
+
+	cm, err := getSetupConfigMap(clientset, releaseName, namespace)
+	if err != nil {
+		return "", err
+	}
+
+	version, err := setupDataVersion(cm.Data["data"])
+	if err != nil {
+		return "", err
+	}
+
+	setupData, err := ReadConfigMap(releaseName, namespace)
+	if err != nil {
+		return version, err
+	}
+
+	// Read the ConfigMap again, as reading the setup data may have moved the credentials
+	cm, err = getSetupConfigMap(clientset, releaseName, namespace)
+	if err != nil {
+		return version, err
+	}
+
+	err = rewriteSetupState(clientset, cm, setupData)
+	if err != nil {
+		return version, err
+	}
+
+	return version, nil
+}
+
+// ListSetupData returns the setup data of every release installed by zctl in the given namespace,
+// or in all namespaces when namespace is empty. The setup data is read without writing to the cluster.
+// The setup state objects that cannot be read are reported by the returned list of errors, one per object, and do
+// not prevent listing the others; the returned error is only set when the objects cannot be listed.
+func ListSetupData(namespace string) ([]SetupData, []error, error) {
+	clientset, err := defaultClientset()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type releaseRef struct {
+		name      string
+		namespace string
+	}
+	refs := []releaseRef{}
+	readErrs := []error{}
+
+	cms, err := clientset.CoreV1().ConfigMaps(namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: ManagedByLabel + "=" + ManagedByValue,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, cm := range cms.Items {
+		if cm.Labels[ReleaseLabel] == "" || cm.Name != SetupConfigMapName(cm.Labels[ReleaseLabel]) {
+			readErrs = append(readErrs, fmt.Errorf("configmap %s/%s is labeled as managed by zctl but is not the setup state of a release", cm.Namespace, cm.Name))
+			continue
+		}
+		refs = append(refs, releaseRef{name: cm.Labels[ReleaseLabel], namespace: cm.Namespace})
+	}
+
+	// ConfigMaps written by older zctl versions are not labeled
+	legacyCms, err := clientset.CoreV1().ConfigMaps(namespace).List(context.Background(), metav1.ListOptions{
+		FieldSelector: "metadata.name=" + legacySetupConfigMapName,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, cm := range legacyCms.Items {
+		legacyData, err := decodeSetupData(cm.Data["data"])
+		if err != nil {
+			readErrs = append(readErrs, fmt.Errorf("error reading setup data in configmap %s/%s: %w", cm.Namespace, cm.Name, err))
+			continue
+		}
+		refs = append(refs, releaseRef{name: legacyData.ReleaseName, namespace: cm.Namespace})
+	}
+
+	setupDataList := []SetupData{}
+	for _, ref := range refs {
+		setupData, err := ReadSetupState(ref.name, ref.namespace)
+		if err != nil {
+			readErrs = append(readErrs, fmt.Errorf("error reading setup data for release %s in namespace %s: %w", ref.name, ref.namespace, err))
+			continue
+		}
+		setupDataList = append(setupDataList, setupData)
+	}
+
+	return setupDataList, readErrs, nil
+}
+
+// getSetupConfigMap returns the ConfigMap holding the setup data of a release.
+// A ConfigMap written by an older zctl under the shared legacy name is moved to the per release name
+// when it belongs to the release, so that other releases can be installed in the same namespace.
+func getSetupConfigMap(clientset *kubernetes.Clientset, releaseName string, namespace string) (*corev1.ConfigMap, error) {
+	legacy, isLegacy, err := findSetupConfigMap(clientset, releaseName, namespace)
+	if err != nil || !isLegacy {
+		return legacy, err
+	}
+
+	// Move the legacy ConfigMap to the per release name
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      SetupConfigMapName(releaseName),
+			Namespace: namespace,
+			Labels:    setupLabels(releaseName),
+		},
+		Data: legacy.Data,
+	}
+	cm, err = clientset.CoreV1().ConfigMaps(namespace).Create(context.Background(), cm, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = clientset.CoreV1().ConfigMaps(namespace).Delete(context.Background(), legacySetupConfigMapName, metav1.DeleteOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Moved setup data of release %s from configmap %s to %s\n", releaseName, legacySetupConfigMapName, cm.Name)
+
+	return cm, nil
+}
+
+// findSetupConfigMap returns the ConfigMap holding the setup data of a release without modifying it, and whether
+// it is a ConfigMap written by an older zctl under the shared legacy name.
+func findSetupConfigMap(clientset *kubernetes.Clientset, releaseName string, namespace string) (*corev1.ConfigMap, bool, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), SetupConfigMapName(releaseName), metav1.GetOptions{})
+	if err == nil || !apierrors.IsNotFound(err) {
+		return cm, false, err
+	}
+	notFound := err
+
+	legacy, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), legacySetupConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, false, notFound
+		}
+		return nil, false, err
+	}
+
+	legacyData, err := decodeSetupData(legacy.Data["data"])
+	if err != nil {
+		return nil, false, err
+	}
+	if legacyData.ReleaseName != releaseName {
+		return nil, false, notFound
+	}
+
+	return legacy, true, nil
+}
+
+// revisionKey returns the ConfigMap key holding the setup data snapshot for a helm revision.
+func revisionKey(revision int) string {
+	return fmt.Sprintf("revision-%d", revision)
+}
+
+// pruneRevisionSnapshots removes the snapshots of the helm revisions older than the last revisionSnapshots revisions
+// up to the given one.
+func pruneRevisionSnapshots(data map[string]string, revision int) {
+	for key := range data {
+		var snapshotRevision int
+		if _, err := fmt.Sscanf(key, "revision-%d", &snapshotRevision); err != nil {
+			continue
+		}
+		if snapshotRevision <= revision-revisionSnapshots {
+			delete(data, key)
+		}
+	}
+}
+
+// DeleteConfigMap deletes the ConfigMap and the Secret holding the setup data of the given release.
+func DeleteConfigMap(releaseName string, namespace string) error {
+	clientset, err := defaultClientset()
 	if err != nil {
 		return err
 	}
 
+	// Make sure the ConfigMap belongs to the release before deleting it
+	cm, err := getSetupConfigMap(clientset, releaseName, namespace)
+	if err != nil {
+		return err
+	}
+
+	// Delete the Secret
+	err = clientset.CoreV1().Secrets(namespace).Delete(context.Background(), cm.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	// Delete the ConfigMap
+	err = clientset.CoreV1().ConfigMaps(namespace).Delete(context.Background(), cm.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
 	fmt.Println("ConfigMap deleted successfully")
 
 	return nil
@@ -195,33 +611,43 @@ func Kubeconfig() (*clientcmdapi.Config, error) {
 	return &raw, nil
 }
 
+// GetCurrentKubeContextAPIEndpoint returns the API server of the current kube context, loading the kubeconfig files
+// like kubectl does (KUBECONFIG or the default file). It fails when no current context is set or it is not found.
 func GetCurrentKubeContextAPIEndpoint() (string, error) {
-	// Use the default kubeconfig file to create a Config object.
-	kubeconfig, err := clientcmd.LoadFromFile(clientcmd.RecommendedHomeFile)
+	raw, err := Kubeconfig()
 	if err != nil {
 		return "", err
 	}
 
-	// Use the client to retrieve the current context.
-	currentContext := kubeconfig.CurrentContext
-	context := kubeconfig.Contexts[currentContext]
+	if raw.CurrentContext == "" {
+		return "", fmt.Errorf("no current kube context is set")
+	}
+
+	context, ok := raw.Contexts[raw.CurrentContext]
+	if !ok {
+		return "", fmt.Errorf("current kube context %s not found in the kubeconfig", raw.CurrentContext)
+	}
+
+	cluster, ok := raw.Clusters[context.Cluster]
+	if !ok {
+		return "", fmt.Errorf("cluster %s of kube context %s not found in the kubeconfig", context.Cluster, raw.CurrentContext)
+	}
 
-	// Use the context to retrieve the API server endpoint.
-	cluster := kubeconfig.Clusters[context.Cluster]
 	return cluster.Server, nil
 }
 
 func GetCurrentNamespace() (string, error) {
-	// Use the default kubeconfig file to create a Config object.
-	kubeconfig, err := clientcmd.LoadFromFile(clientcmd.RecommendedHomeFile)
+	raw, err := Kubeconfig()
 	if err != nil {
 		return "", err
 	}
 
-	// Use the client to retrieve the current context.
-
-	return kubeconfig.Contexts[kubeconfig.CurrentContext].Namespace, nil
+	context, ok := raw.Contexts[raw.CurrentContext]
+	if !ok {
+		return "", fmt.Errorf("current kube context %q not found in the kubeconfig", raw.CurrentContext)
+	}
 
+	return context.Namespace, nil
 }
 
 func GetReleaseIdentifierFromReleaseName(releaseName string) string {
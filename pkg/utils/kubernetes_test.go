@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPruneRevisionSnapshots(t *testing.T) {
+	data := map[string]string{"data": "current"}
+	for revision := 1; revision <= 12; revision++ {
+		data[revisionKey(revision)] = "snapshot"
+	}
+
+	pruneRevisionSnapshots(data, 12)
+
+	want := map[string]string{"data": "current"}
+	for revision := 3; revision <= 12; revision++ {
+		want[revisionKey(revision)] = "snapshot"
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("pruneRevisionSnapshots() kept %v, want %v", data, want)
+	}
+}
+
+func TestGetCurrentKubeContextAPIEndpoint(t *testing.T) {
+	clusters := `
+clusters:
+- name: c1
+  cluster:
+    server: https://c1.example.com
+contexts:
+- name: ctx1
+  context:
+    cluster: c1
+- name: orphan
+  context:
+    cluster: missing
+`
+
+	tests := []struct {
+		name           string
+		currentContext string
+		want           string
+		wantErr        bool
+	}{
+		{"current context", "ctx1", "https://c1.example.com", false},
+		{"no current context", "", "", true},
+		{"unknown current context", "ctx2", "", true},
+		{"unknown cluster", "orphan", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config")
+			kubeconfig := "apiVersion: v1\nkind: Config\ncurrent-context: \"" + tt.currentContext + "\"\n" + clusters
+			if err := os.WriteFile(path, []byte(kubeconfig), 0600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("KUBECONFIG", path)
+
+			got, err := GetCurrentKubeContextAPIEndpoint()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("GetCurrentKubeContextAPIEndpoint() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetCurrentKubeContextAPIEndpoint() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetCurrentKubeContextAPIEndpoint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Actions of the resources of an install plan.
+const (
+	PlanActionCreate = "create" // the resource is created by the install
+	PlanActionAdopt  = "adopt"  // an existing resource is used and never deleted by zctl
+	PlanActionKeep   = "keep"   // the resource was created by an earlier attempt of a resumed install
+)
+
+// InstallPlan describes what an install will do, without creating anything.
+type InstallPlan struct {
+	ReleaseName  string            `json:"release_name"`
+	Namespace    string            `json:"namespace"`
+	K8s          string            `json:"k8s"`
+	Identifier   string            `json:"identifier"`
+	Resumed      bool              `json:"resumed"`
+	KubeContext  string            `json:"kube_context"`
+	ClusterName  string            `json:"cluster_name,omitempty"`
+	AccountID    string            `json:"account_id,omitempty"`
+	Region       string            `json:"region,omitempty"`
+	GCPProjectId string            `json:"gcp_project_id,omitempty"`
+	ChartVersion string            `json:"chart_version"`
+	ImageTag     string            `json:"image_tag"`
+	Resources    []PlannedResource `json:"resources"`
+	Manifest     string            `json:"manifest"`
+}
+
+// PlannedResource is a resource an install creates or uses.
+type PlannedResource struct {
+	Action    string            `json:"action"`
+	Kind      string            `json:"kind"`
+	Name      string            `json:"name"`
+	Region    string            `json:"region,omitempty"`
+	Documents map[string]string `json:"documents,omitempty"` // policy documents or bindings, by name
+}
+
+// PlanSetup resolves everything Setup would do for the setup data: the cluster, the account, the names of the cloud
+// resources, their policies and bindings, and the Helm manifests rendered with the computed values.
+// It only reads from the cloud provider and the cluster; nothing is created. Credentials are replaced by placeholders.
+func PlanSetup(setupData SetupData) (InstallPlan, error) {
+	if setupData.ChartVersion == "" {
+		setupData.ChartVersion = DefaultChartVersion
+	}
+	if setupData.ImageTag == "" {
+		setupData.ImageTag = DefaultImageTag
+	}
+
+	journal := &Journal{}
+
+	// An incomplete setup is resumed with its identifier and resources. The setup state is only read, a dry run
+	// never modifies the cluster.
+	existing, err := ReadSetupState(setupData.ReleaseName, setupData.Namespace)
+	if err == nil {
+		if existing.Phase == SetupPhaseInstalled {
+			return InstallPlan{}, fmt.Errorf("setup already exists for release %s in namespace %s", setupData.ReleaseName, setupData.Namespace)
+		}
+		if existing.K8s != setupData.K8s {
+			return InstallPlan{}, fmt.Errorf("an incomplete %s setup exists for release %s in namespace %s", existing.K8s, setupData.ReleaseName, setupData.Namespace)
+		}
+		if err := checkResumeInputs(existing, setupData); err != nil {
+			return InstallPlan{}, err
+		}
+		setupData.Identifier = existing.Identifier
+		journal.Entries = existing.Journal
+	}
+
+	plan := InstallPlan{
+		ReleaseName:  setupData.ReleaseName,
+		Namespace:    setupData.Namespace,
+		K8s:          setupData.K8s,
+		Identifier:   setupData.Identifier,
+		Resumed:      len(journal.Entries) > 0,
+		ChartVersion: setupData.ChartVersion,
+		ImageTag:     setupData.ImageTag,
+		Resources:    []PlannedResource{},
+	}
+
+	// plannedAction returns the action for a resource that is created unless an earlier attempt already created it
+	plannedAction := func(kind string) string {
+		if _, ok := journal.Find(kind); ok {
+			return PlanActionKeep
+		}
+		return PlanActionCreate
+	}
+
+	plan.KubeContext, err = CurrentKubeContext()
+	if err != nil {
+		return plan, err
+	}
+
+	if setupData.K8s == "eks" {
+		setupData, err = planAWS(setupData, &plan, plannedAction)
+		if err != nil {
+			return plan, err
+		}
+	} else if setupData.K8s == "gke" {
+		setupData = planGCP(setupData, &plan, plannedAction)
+	} else if setupData.K8s != "plain" {
+		return plan, fmt.Errorf("k8s type not supported")
+	}
+
+	// Credentials never end up in the plan
+	if setupData.S3AccessKey != "" {
+		setupData.S3AccessKey = PlaceholderAccessKey
+	}
+	if setupData.S3SecretKey != "" {
+		setupData.S3SecretKey = PlaceholderSecretKey
+	}
+
+	if action := plannedAction(ResourceHelmRelease); action == PlanActionKeep {
+		plan.Resources = append(plan.Resources, PlannedResource{Action: action, Kind: ResourceHelmRelease, Name: setupData.ReleaseName})
+	} else {
+		if _, err := GetRelease(plan.KubeContext, setupData.ReleaseName, setupData.Namespace); err == nil {
+			return plan, fmt.Errorf("helm release %s already exists in namespace %s", setupData.ReleaseName, setupData.Namespace)
+		}
+		plan.Resources = append(plan.Resources, PlannedResource{Action: action, Kind: ResourceHelmRelease, Name: setupData.ReleaseName})
+	}
+
+	_, rel, err := RenderHelmChart(setupData, "")
+	if err != nil {
+		return plan, err
+	}
+	plan.Manifest = RenderedManifests(rel)
+
+	return plan, nil
+}
+
+// planAWS resolves the EKS cluster, the AWS account, the bucket and the IAM role with its trust and inline policies.
+// It returns the setup data with the bucket and role ARN the install would use.
+func planAWS(setupData SetupData, plan *InstallPlan, plannedAction func(kind string) string) (SetupData, error) {
+	clusterName, err := GetCurrentEKSClusterName(setupData.Region)
+	if err != nil {
+		return setupData, err
+	}
+	setupData.ClusterName = clusterName
+
+	accountId, err := GetAWSAccountID()
+	if err != nil {
+		return setupData, err
+	}
+
+	plan.ClusterName = clusterName
+	plan.AccountID = accountId
+	plan.Region = setupData.Region
+
+	// The IAM OIDC provider of the cluster issuer, which is never deleted by zctl, is only used in irsa mode
+	issuer := ""
+	trustPolicy := GetPodIdentityTrustPolicyDocument()
+	if setupData.AWSIdentity != AWSIdentityPodIdentity {
+		issuer, err = GetEKSOIDCIssuer(setupData.ClusterName, setupData.Region)
+		if err != nil {
+			return setupData, err
+		}
+		trustPolicy = GetIAMTrustPolicyDocument(accountId, issuer)
+
+		exists, err := HasOIDCProvider(setupData.ClusterName, setupData.Region)
+		if err != nil {
+			return setupData, err
+		}
+		if !exists {
+			if !setupData.CreateOIDCProvider {
+				return setupData, fmt.Errorf("the OIDC issuer of cluster %s is not registered as an IAM OIDC provider. Install with --create_oidc_provider to create it", setupData.ClusterName)
+			}
+			plan.Resources = append(plan.Resources, PlannedResource{Action: PlanActionCreate, Kind: ResourceIAMOIDCProvider, Name: OIDCProviderArn(accountId, issuer)})
+		}
+	}
+
+	// The bucket
+	bucketName := "zinc-observe-" + setupData.Identifier + "-" + setupData.ClusterName + "-" + setupData.ReleaseName
+	if setupData.BucketName != "" {
+		bucketName = setupData.BucketName
+		setupData.BucketRegion, err = GetS3BucketRegion(bucketName, setupData.Region)
+		if err != nil {
+			return setupData, err
+		}
+		exists, err := S3BucketExists(bucketName, setupData.BucketRegion)
+		if err != nil {
+			return setupData, err
+		}
+		if !exists {
+			return setupData, fmt.Errorf("bucket %s does not exist", bucketName)
+		}
+		plan.Resources = append(plan.Resources, PlannedResource{Action: PlanActionAdopt, Kind: ResourceS3Bucket, Name: bucketName, Region: setupData.BucketRegion})
+	} else {
+		setupData.BucketRegion = setupData.Region
+
+		// The KMS key created for the bucket is named by the ARN of its alias, its key ID is only known once it exists
+		if setupData.BucketOptions != nil && setupData.BucketOptions.CreateKMSKey {
+			options := *setupData.BucketOptions
+			options.KMSKeyID = "arn:aws:kms:" + setupData.Region + ":" + accountId + ":" + KMSKeyAlias(setupData)
+			setupData.BucketOptions = &options
+			plan.Resources = append(plan.Resources, PlannedResource{
+				Action:    plannedAction(ResourceKMSKey),
+				Kind:      ResourceKMSKey,
+				Name:      options.KMSKeyID,
+				Region:    setupData.Region,
+				Documents: map[string]string{"key-policy": GetKMSKeyPolicyDocument(accountId)},
+			})
+		}
+
+		resource := PlannedResource{Action: plannedAction(ResourceS3Bucket), Kind: ResourceS3Bucket, Name: bucketName, Region: setupData.Region}
+		if setupData.BucketOptions != nil {
+			resource.Documents = map[string]string{"settings": describeS3BucketOptions(setupData)}
+		}
+		plan.Resources = append(plan.Resources, resource)
+	}
+	setupData.BucketName = bucketName
+
+	// The IAM role
+	if setupData.IamRole != "" {
+		err = VerifyAdoptedIAMRole(setupData.IamRole, setupData.Region, setupData.AWSIdentity, accountId, issuer, bucketName, BucketKMSKey(setupData))
+		if err != nil {
+			return setupData, err
+		}
+		setupData.IamRole, err = GetIAMRoleArn(setupData.IamRole, setupData.Region)
+		if err != nil {
+			return setupData, err
+		}
+		plan.Resources = append(plan.Resources, PlannedResource{Action: PlanActionAdopt, Kind: ResourceIAMRole, Name: setupData.IamRole})
+	} else {
+		roleName := "zinc-observe-" + setupData.Identifier + "-" + setupData.ClusterName + "-" + setupData.ReleaseName
+		setupData.IamRole = "arn:aws:iam::" + accountId + ":role/" + roleName
+		plan.Resources = append(plan.Resources, PlannedResource{
+			Action: plannedAction(ResourceIAMRole),
+			Kind:   ResourceIAMRole,
+			Name:   setupData.IamRole,
+			Documents: map[string]string{
+				"trust-policy":        trustPolicy,
+				"inline-policy/zo-s3": GetS3PolicyDocument(bucketName, BucketKMSKey(setupData)),
+			},
+		})
+	}
+
+	// The Pod Identity association of the role with the service account of the chart
+	if setupData.AWSIdentity == AWSIdentityPodIdentity {
+		plan.Resources = append(plan.Resources, PlannedResource{
+			Action: plannedAction(ResourcePodIdentityAssociation),
+			Kind:   ResourcePodIdentityAssociation,
+			Name:   setupData.Namespace + "/" + PodIdentityServiceAccountName(setupData),
+		})
+	}
+
+	return setupData, nil
+}
+
+// describeS3BucketOptions returns the settings ApplyS3BucketOptions applies to the bucket of the setup data, as JSON.
+func describeS3BucketOptions(setupData SetupData) string {
+	settings := map[string]interface{}{
+		"encryption":          setupData.BucketOptions.Encryption,
+		"versioning":          setupData.BucketOptions.Versioning,
+		"block_public_access": true,
+		"object_ownership":    "BucketOwnerEnforced",
+		"tags":                S3BucketTags(setupData),
+	}
+	if setupData.BucketOptions.KMSKeyID != "" {
+		settings["kms_key_id"] = setupData.BucketOptions.KMSKeyID
+	}
+	if setupData.BucketOptions.CreateKMSKey {
+		settings["create_kms_key"] = true
+	}
+
+	b, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+
+	return string(b)
+}
+
+// planGCP resolves the GCS bucket, the service account, its binding on the bucket and its HMAC key.
+// It returns the setup data with the bucket and service account the install would use, and placeholder credentials.
+func planGCP(setupData SetupData, plan *InstallPlan, plannedAction func(kind string) string) SetupData {
+	plan.GCPProjectId = setupData.GCPProjectId
+
+	setupData.BucketName = "zinc-observe-" + setupData.Identifier + "-" + setupData.ReleaseName
+	setupData.ServiceAccount = "zinc-observe-" + setupData.Identifier + "@" + setupData.GCPProjectId + ".iam.gserviceaccount.com"
+	setupData.S3AccessKey = "<hmac-access-id>"
+	setupData.S3SecretKey = "<hmac-secret>"
+
+	plan.Resources = append(plan.Resources,
+		PlannedResource{Action: plannedAction(ResourceGCSBucket), Kind: ResourceGCSBucket, Name: setupData.BucketName, Region: "US"},
+		PlannedResource{
+			Action: plannedAction(ResourceGCPServiceAccount),
+			Kind:   ResourceGCPServiceAccount,
+			Name:   setupData.ServiceAccount,
+			Documents: map[string]string{
+				"bucket-binding": fmt.Sprintf("roles/storage.objectAdmin on gs://%s for serviceAccount:%s", setupData.BucketName, setupData.ServiceAccount),
+			},
+		},
+		PlannedResource{Action: plannedAction(ResourceHMACKey), Kind: ResourceHMACKey, Name: "HMAC key of " + setupData.ServiceAccount},
+	)
+
+	return setupData
+}
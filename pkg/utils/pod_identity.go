@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+)
+
+// Modes of the AWS identity of the ZincObserve pods on EKS.
+const (
+	AWSIdentityIRSA        = "irsa"         // IAM roles for service accounts, through the IAM OIDC provider of the cluster
+	AWSIdentityPodIdentity = "pod-identity" // EKS Pod Identity, through the EKS Pod Identity Agent of the cluster
+)
+
+// PodIdentityServiceAccountName returns the name of the Kubernetes service account of the chart in pod-identity mode.
+// It is set explicitly in the chart values, since the Pod Identity association is created for it before the install.
+func PodIdentityServiceAccountName(setupData SetupData) string {
+	return setupData.ReleaseName + "-zincobserve"
+}
+
+// parsePodIdentityAssociationArn returns the cluster name and the association ID of a Pod Identity association ARN:
+// arn:aws:eks:<region>:<account>:podidentityassociation/<cluster>/<id>
+func parsePodIdentityAssociationArn(associationArn string) (string, string, error) {
+	parts := strings.Split(associationArn[strings.LastIndex(associationArn, ":")+1:], "/")
+	if len(parts) != 3 || parts[0] != "podidentityassociation" {
+		return "", "", fmt.Errorf("invalid Pod Identity association ARN %s", associationArn)
+	}
+
+	return parts[1], parts[2], nil
+}
+
+// arnRegion returns the region of an ARN: arn:<partition>:<service>:<region>:<account>:<resource>
+func arnRegion(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) < 6 {
+		return ""
+	}
+
+	return parts[3]
+}
+
+// CreatePodIdentityAssociation associates the IAM role with the service account of the namespace through EKS Pod Identity.
+// It returns the ARN of the association.
+func CreatePodIdentityAssociation(clusterName, region, namespace, serviceAccount, roleArn string) (string, error) {
+	fmt.Printf("Creating Pod Identity association for service account %s/%s...\n", namespace, serviceAccount)
+
+	// Create a new EKS client.
+	svc, err := newEKSClient(region)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := svc.CreatePodIdentityAssociation(context.Background(), &eks.CreatePodIdentityAssociationInput{
+		ClusterName:    aws.String(clusterName),
+		Namespace:      aws.String(namespace),
+		ServiceAccount: aws.String(serviceAccount),
+		RoleArn:        aws.String(roleArn),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	associationArn := aws.ToString(resp.Association.AssociationArn)
+	fmt.Println("Created Pod Identity association: ", associationArn)
+
+	return associationArn, nil
+}
+
+// PodIdentityAssociationExists checks whether the Pod Identity association with the specified ARN exists.
+func PodIdentityAssociationExists(associationArn string) (bool, error) {
+	clusterName, associationId, err := parsePodIdentityAssociationArn(associationArn)
+	if err != nil {
+		return false, err
+	}
+
+	// Create a new EKS client for the region of the association.
+	svc, err := newEKSClient(arnRegion(associationArn))
+	if err != nil {
+		return false, err
+	}
+
+	_, err = svc.DescribePodIdentityAssociation(context.Background(), &eks.DescribePodIdentityAssociationInput{
+		ClusterName:   aws.String(clusterName),
+		AssociationId: aws.String(associationId),
+	})
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeletePodIdentityAssociation deletes the Pod Identity association with the specified ARN.
+// An association that does not exist counts as deleted.
+func DeletePodIdentityAssociation(associationArn string) error {
+	clusterName, associationId, err := parsePodIdentityAssociationArn(associationArn)
+	if err != nil {
+		return err
+	}
+
+	// Create a new EKS client for the region of the association.
+	svc, err := newEKSClient(arnRegion(associationArn))
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.DeletePodIdentityAssociation(context.Background(), &eks.DeletePodIdentityAssociationInput{
+		ClusterName:   aws.String(clusterName),
+		AssociationId: aws.String(associationId),
+	})
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			fmt.Println("Pod Identity association already deleted: ", associationArn)
+			return nil
+		}
+		return err
+	}
+
+	fmt.Println("Deleted Pod Identity association: ", associationArn)
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package utils
+
+import "testing"
+
+func TestParsePodIdentityAssociationArn(t *testing.T) {
+	tests := []struct {
+		name        string
+		arn         string
+		wantCluster string
+		wantID      string
+		wantErr     bool
+	}{
+		{
+			name:        "association",
+			arn:         "arn:aws:eks:us-east-1:123456789012:podidentityassociation/dev2/a-abcdefghijklmnop1",
+			wantCluster: "dev2",
+			wantID:      "a-abcdefghijklmnop1",
+		},
+		{
+			name:        "other partition",
+			arn:         "arn:aws-cn:eks:cn-north-1:123456789012:podidentityassociation/dev2/a-1",
+			wantCluster: "dev2",
+			wantID:      "a-1",
+		},
+		{
+			name:    "cluster ARN",
+			arn:     "arn:aws:eks:us-east-1:123456789012:cluster/dev2",
+			wantErr: true,
+		},
+		{
+			name:    "missing association ID",
+			arn:     "arn:aws:eks:us-east-1:123456789012:podidentityassociation/dev2",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			arn:     "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, id, err := parsePodIdentityAssociationArn(tt.arn)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parsePodIdentityAssociationArn(%q) want an error", tt.arn)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePodIdentityAssociationArn(%q) error = %v", tt.arn, err)
+			}
+			if cluster != tt.wantCluster || id != tt.wantID {
+				t.Errorf("parsePodIdentityAssociationArn(%q) = %s, %s, want %s, %s", tt.arn, cluster, id, tt.wantCluster, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VolumeClaims identifies the PersistentVolumeClaims holding the data of a release: the claims created from the
+// volumeClaimTemplates of its StatefulSets (ingester, etcd, MinIO), named <template>-<statefulset>-<ordinal>,
+// and the claims of its manifest.
+type VolumeClaims struct {
+	Prefixes []string `json:"prefixes,omitempty"`
+	Names    []string `json:"names,omitempty"`
+}
+
+// FindVolumeClaims finds the PersistentVolumeClaims of the release in its manifest. It must be called before the
+// release is uninstalled, since the manifest is removed with it.
+func FindVolumeClaims(setupData SetupData) (VolumeClaims, error) {
+	claims := VolumeClaims{}
+
+	kubeContext, err := SetupKubeContext(setupData)
+	if err != nil {
+		return claims, err
+	}
+
+	rel, err := GetRelease(kubeContext, setupData.ReleaseName, setupData.Namespace)
+	if err != nil {
+		return claims, err
+	}
+
+	for _, manifest := range releaseutil.SplitManifests(rel.Manifest) {
+		obj := manifestObject{}
+		if err := yaml.Unmarshal([]byte(manifest), &obj); err != nil {
+			return claims, err
+		}
+
+		switch obj.Kind {
+		case "StatefulSet":
+			for _, template := range obj.Spec.VolumeClaimTemplates {
+				claims.Prefixes = append(claims.Prefixes, template.Metadata.Name+"-"+obj.Metadata.Name+"-")
+			}
+		case "PersistentVolumeClaim":
+			claims.Names = append(claims.Names, obj.Metadata.Name)
+		}
+	}
+
+	return claims, nil
+}
+
+// DeleteVolumeClaims deletes the PersistentVolumeClaims of a release in the namespace. Claims of StatefulSets are
+// matched by name, so that the claims of every replica the StatefulSets ever had are deleted.
+func DeleteVolumeClaims(namespace string, claims VolumeClaims) error {
+	clientset, err := defaultClientset()
+	if err != nil {
+		return err
+	}
+
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	ordinal := regexp.MustCompile(`^[0-9]+$`)
+	failures := []string{}
+	for _, pvc := range pvcs.Items {
+		matched := false
+		for _, prefix := range claims.Prefixes {
+			if strings.HasPrefix(pvc.Name, prefix) && ordinal.MatchString(strings.TrimPrefix(pvc.Name, prefix)) {
+				matched = true
+			}
+		}
+		for _, name := range claims.Names {
+			matched = matched || pvc.Name == name
+		}
+		if !matched {
+			continue
+		}
+
+		err := clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(context.Background(), pvc.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			failures = append(failures, fmt.Sprintf("%s: %v", pvc.Name, err))
+			continue
+		}
+		fmt.Println("Deleted PersistentVolumeClaim: ", pvc.Name)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to delete PersistentVolumeClaims: %s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// PurgeBucket empties and deletes the bucket of the installation: the S3 bucket on EKS, the GCS bucket on GKE or the
+// bucket of the external S3 compatible server (e.g. MinIO) on plain k8s. A MinIO installed by the chart keeps its data
+// in PersistentVolumeClaims, which are deleted with DeleteVolumeClaims. Adopted buckets are never deleted.
+func PurgeBucket(setupData SetupData) error {
+	if setupData.AdoptedBucket {
+		fmt.Println("Keeping adopted bucket: ", setupData.BucketName)
+		return nil
+	}
+
+	switch setupData.K8s {
+	case "eks":
+		exists, err := S3BucketExists(setupData.BucketName, S3BucketRegion(setupData))
+		if err != nil || !exists {
+			return err
+		}
+		err = EmptyS3Bucket(setupData.BucketName, S3BucketRegion(setupData))
+		if err != nil {
+			return err
+		}
+		return DeleteS3Bucket(setupData.BucketName, S3BucketRegion(setupData))
+	case "gke":
+		exists, err := GCSBucketExists(setupData.BucketName)
+		if err != nil || !exists {
+			return err
+		}
+		err = EmptyGCSBucket(setupData.BucketName)
+		if err != nil {
+			return err
+		}
+		return DeleteGCSBucket(setupData)
+	case "plain":
+		if setupData.InstallMinIO || setupData.BucketName == "" {
+			return nil
+		}
+		err := EmptyS3CompatibleBucket(setupData.S3ServerURL, setupData.S3AccessKey, setupData.S3SecretKey, setupData.BucketName)
+		if err != nil {
+			return err
+		}
+		return DeleteS3CompatibleBucket(setupData.S3ServerURL, setupData.S3AccessKey, setupData.S3SecretKey, setupData.BucketName)
+	}
+
+	return nil
+}
+
+// emptyJournalBuckets empties the buckets recorded in the journal of a failed setup, so that its rollback can delete them.
+func emptyJournalBuckets(entries []JournalEntry) error {
+	for _, entry := range entries {
+		var err error
+		switch entry.Kind {
+		case ResourceS3Bucket:
+			var exists bool
+			exists, err = S3BucketExists(entry.Name, entry.Region)
+			if err == nil && exists {
+				err = EmptyS3Bucket(entry.Name, entry.Region)
+			}
+		case ResourceGCSBucket:
+			var exists bool
+			exists, err = GCSBucketExists(entry.Name)
+			if err == nil && exists {
+				err = EmptyGCSBucket(entry.Name)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
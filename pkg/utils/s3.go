@@ -1,49 +1,62 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
-// CreateS3Bucket creates an S3 bucket with the specified name.
+// s3BucketWaitTimeout is how long the creation or deletion of a bucket is waited for.
+const s3BucketWaitTimeout = 2 * time.Minute
+
+// CreateS3Bucket creates an S3 bucket with the specified name in the region.
+// Outside of us-east-1, S3 requires the region of the bucket as its location constraint.
 func CreateS3Bucket(bucketName, region string) error {
 	if region == "" {
-		region = "us-west-2"
+		return fmt.Errorf("no AWS region given for bucket %s", bucketName)
 	}
 
 	fmt.Println(".Creating S3 Bucket............")
 
-	// Create a new AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(region), // Specify the AWS region
-	})
+	// Create a new S3 client
+	s3Client, err := newS3Client(region)
 	if err != nil {
 		return err
 	}
 
-	// Create a new S3 client
-	s3Client := s3.New(sess)
+	input := &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName), // Specify the bucket name
+	}
+	if region != "us-east-1" {
+		input.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+			LocationConstraint: types.BucketLocationConstraint(region),
+		}
+	}
 
 	// Create the S3 bucket
-	_, err = s3Client.CreateBucket(&s3.CreateBucketInput{
-		Bucket: aws.String(bucketName), // Specify the bucket name
-	})
+	_, err = s3Client.CreateBucket(context.Background(), input)
 	if err != nil {
 		return err
 	}
 
 	// Wait for the bucket to exist
-	err = s3Client.WaitUntilBucketExists(&s3.HeadBucketInput{
+	err = s3.NewBucketExistsWaiter(s3Client).Wait(context.Background(), &s3.HeadBucketInput{
 		Bucket: aws.String(bucketName),
-	})
+	}, s3BucketWaitTimeout)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Bucket created: ", bucketName)
+	fmt.Printf("Bucket created: %s (%s)\n", bucketName, region)
 
 	return nil
 }
@@ -51,23 +64,19 @@ func CreateS3Bucket(bucketName, region string) error {
 // delete s3 bucket
 func DeleteS3Bucket(bucketName, region string) error {
 	if region == "" {
-		region = "us-west-2"
+		return fmt.Errorf("no AWS region given for bucket %s", bucketName)
 	}
 	fmt.Println("DeleteS3Bucket............")
-	// Create a new session with the AWS configuration
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	})
+
+	// Create a new S3 client
+	s3Client, err := newS3Client(region)
 	if err != nil {
-		fmt.Println("error occured creating new aws session for deleting s3 bucket: ", err)
+		fmt.Println("error occured loading the aws configuration for deleting s3 bucket: ", err)
 		return err
 	}
 
-	// Create a new S3 client
-	s3Client := s3.New(sess)
-
 	// Delete the S3 bucket
-	_, err = s3Client.DeleteBucket(&s3.DeleteBucketInput{
+	_, err = s3Client.DeleteBucket(context.Background(), &s3.DeleteBucketInput{
 		Bucket: aws.String(bucketName),
 	})
 	if err != nil {
@@ -76,12 +85,370 @@ func DeleteS3Bucket(bucketName, region string) error {
 	}
 
 	// Wait until the bucket is deleted
-	err = s3Client.WaitUntilBucketNotExists(&s3.HeadBucketInput{
+	err = s3.NewBucketNotExistsWaiter(s3Client).Wait(context.Background(), &s3.HeadBucketInput{
+		Bucket: aws.String(bucketName),
+	}, s3BucketWaitTimeout)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// S3BucketExists checks whether the specified S3 bucket exists and is accessible.
+func S3BucketExists(bucketName, region string) (bool, error) {
+	if region == "" {
+		return false, fmt.Errorf("no AWS region given for bucket %s", bucketName)
+	}
+
+	// Create a new S3 client
+	s3Client, err := newS3Client(region)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s3Client.HeadBucket(context.Background(), &s3.HeadBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		if isS3BucketNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetS3BucketRegion returns the region an existing S3 bucket is located in, as reported by GetBucketLocation.
+// The request is sent to the specified region, S3 answers it for the buckets of every region.
+func GetS3BucketRegion(bucketName, region string) (string, error) {
+	if region == "" {
+		return "", fmt.Errorf("no AWS region given for bucket %s", bucketName)
+	}
+
+	// Create a new S3 client
+	s3Client, err := newS3Client(region)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s3Client.GetBucketLocation(context.Background(), &s3.GetBucketLocationInput{
 		Bucket: aws.String(bucketName),
 	})
+	if err != nil {
+		if isS3BucketNotFound(err) {
+			return "", fmt.Errorf("bucket %s does not exist", bucketName)
+		}
+		return "", fmt.Errorf("failed to get the location of bucket %s: %v", bucketName, err)
+	}
+
+	// Buckets of us-east-1 have no location constraint, and the legacy EU constraint is eu-west-1
+	switch resp.LocationConstraint {
+	case "":
+		return "us-east-1", nil
+	case types.BucketLocationConstraintEu:
+		return "eu-west-1", nil
+	default:
+		return string(resp.LocationConstraint), nil
+	}
+}
+
+// S3BucketRegion returns the region of the S3 bucket of an installation. The bucket of an installation recorded
+// without the region of its bucket is in the region of the installation.
+func S3BucketRegion(setupData SetupData) string {
+	if setupData.BucketRegion != "" {
+		return setupData.BucketRegion
+	}
+
+	return setupData.Region
+}
+
+// isS3BucketNotFound reports whether an S3 error means that the bucket does not exist.
+func isS3BucketNotFound(err error) bool {
+	var notFound *types.NotFound
+	var noSuchBucket *types.NoSuchBucket
+	if errors.As(err, &notFound) || errors.As(err, &noSuchBucket) {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchBucket")
+}
+
+// Default encryptions of the S3 bucket created by zctl.
+const (
+	BucketEncryptionSSES3  = "sse-s3"  // S3 managed keys (AES256)
+	BucketEncryptionSSEKMS = "sse-kms" // a KMS key, see S3BucketOptions.KMSKeyID
+)
+
+// S3BucketTags returns the tags of the S3 bucket of an installation: the standard tags identifying the installation,
+// and the tags of its bucket options.
+func S3BucketTags(setupData SetupData) map[string]string {
+	tags := map[string]string{
+		"managed-by":            "zctl",
+		"zincobserve-release":   setupData.ReleaseName,
+		"zincobserve-namespace": setupData.Namespace,
+		"zincobserve-cluster":   setupData.ClusterName,
+	}
+
+	if setupData.BucketOptions != nil {
+		for key, value := range setupData.BucketOptions.Tags {
+			tags[key] = value
+		}
+	}
+
+	return tags
+}
+
+// ApplyS3BucketOptions applies the settings of the S3 bucket of an installation: S3 Block Public Access, enforced
+// bucket owner object ownership, default encryption, versioning and tags. Tags set outside of zctl are kept.
+// Versioning is suspended when it is disabled on a bucket where it was enabled.
+func ApplyS3BucketOptions(bucketName, region string, options S3BucketOptions, tags map[string]string) error {
+	if region == "" {
+		return fmt.Errorf("no AWS region given for bucket %s", bucketName)
+	}
+
+	fmt.Println("Applying the settings of S3 bucket: ", bucketName)
+
+	// Create a new S3 client
+	s3Client, err := newS3Client(region)
 	if err != nil {
 		return err
 	}
 
+	_, err = s3Client.PutPublicAccessBlock(context.Background(), &s3.PutPublicAccessBlockInput{
+		Bucket: aws.String(bucketName),
+		PublicAccessBlockConfiguration: &types.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to block public access to bucket %s: %v", bucketName, err)
+	}
+
+	_, err = s3Client.PutBucketOwnershipControls(context.Background(), &s3.PutBucketOwnershipControlsInput{
+		Bucket: aws.String(bucketName),
+		OwnershipControls: &types.OwnershipControls{
+			Rules: []types.OwnershipControlsRule{
+				{ObjectOwnership: types.ObjectOwnershipBucketOwnerEnforced},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enforce the object ownership of bucket %s: %v", bucketName, err)
+	}
+
+	encryption := &types.ServerSideEncryptionByDefault{
+		SSEAlgorithm: types.ServerSideEncryptionAes256,
+	}
+	if options.Encryption == BucketEncryptionSSEKMS {
+		encryption = &types.ServerSideEncryptionByDefault{
+			SSEAlgorithm:   types.ServerSideEncryptionAwsKms,
+			KMSMasterKeyID: aws.String(options.KMSKeyID),
+		}
+	}
+	_, err = s3Client.PutBucketEncryption(context.Background(), &s3.PutBucketEncryptionInput{
+		Bucket: aws.String(bucketName),
+		ServerSideEncryptionConfiguration: &types.ServerSideEncryptionConfiguration{
+			Rules: []types.ServerSideEncryptionRule{
+				{
+					ApplyServerSideEncryptionByDefault: encryption,
+					BucketKeyEnabled:                   aws.Bool(options.Encryption == BucketEncryptionSSEKMS),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set the default encryption of bucket %s: %v", bucketName, err)
+	}
+
+	// A bucket where versioning was never enabled is left unversioned
+	versioning, err := s3Client.GetBucketVersioning(context.Background(), &s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		return err
+	}
+	if options.Versioning || versioning.Status == types.BucketVersioningStatusEnabled {
+		status := types.BucketVersioningStatusSuspended
+		if options.Versioning {
+			status = types.BucketVersioningStatusEnabled
+		}
+		_, err = s3Client.PutBucketVersioning(context.Background(), &s3.PutBucketVersioningInput{
+			Bucket: aws.String(bucketName),
+			VersioningConfiguration: &types.VersioningConfiguration{
+				Status: status,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set the versioning of bucket %s: %v", bucketName, err)
+		}
+	}
+
+	// The tags replace the whole tag set, so the tags set outside of zctl are merged in
+	merged := map[string]string{}
+	existing, err := s3Client.GetBucketTagging(context.Background(), &s3.GetBucketTaggingInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "NoSuchTagSet" {
+			return err
+		}
+	} else {
+		for _, tag := range existing.TagSet {
+			merged[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+	for key, value := range tags {
+		merged[key] = value
+	}
+
+	tagSet := []types.Tag{}
+	for key, value := range merged {
+		tagSet = append(tagSet, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	_, err = s3Client.PutBucketTagging(context.Background(), &s3.PutBucketTaggingInput{
+		Bucket:  aws.String(bucketName),
+		Tagging: &types.Tagging{TagSet: tagSet},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to tag bucket %s: %v", bucketName, err)
+	}
+
+	return nil
+}
+
+// purgeWorkers is the number of batches of objects deleted in parallel when a bucket is emptied.
+const purgeWorkers = 8
+
+// EmptyS3Bucket deletes all the objects of an S3 bucket, including every version and delete marker.
+func EmptyS3Bucket(bucketName, region string) error {
+	if region == "" {
+		return fmt.Errorf("no AWS region given for bucket %s", bucketName)
+	}
+
+	// Create a new S3 client
+	s3Client, err := newS3Client(region)
+	if err != nil {
+		return err
+	}
+
+	return emptyBucket(s3Client, bucketName)
+}
+
+// newS3CompatibleClient creates a client for an S3 compatible server like MinIO, using path-style addressing.
+func newS3CompatibleClient(serverURL, accessKey, secretKey string) *s3.Client {
+	return s3.New(s3.Options{
+		Region:       "us-east-1", // Dummy region required by aws sdk
+		BaseEndpoint: aws.String(serverURL),
+		Credentials:  credentials.NewStaticCredentialsProvider(accessKey, secretKey, ""),
+		UsePathStyle: true,
+	})
+}
+
+// EmptyS3CompatibleBucket deletes all the objects of a bucket on an S3 compatible server like MinIO, including every version.
+func EmptyS3CompatibleBucket(serverURL, accessKey, secretKey, bucketName string) error {
+	return emptyBucket(newS3CompatibleClient(serverURL, accessKey, secretKey), bucketName)
+}
+
+// DeleteS3CompatibleBucket deletes an empty bucket on an S3 compatible server like MinIO.
+func DeleteS3CompatibleBucket(serverURL, accessKey, secretKey, bucketName string) error {
+	s3Client := newS3CompatibleClient(serverURL, accessKey, secretKey)
+
+	_, err := s3Client.DeleteBucket(context.Background(), &s3.DeleteBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Bucket deleted: ", bucketName)
+
+	return nil
+}
+
+// emptyBucket deletes every object version and delete marker of a bucket. The versions are listed page by page,
+// and each page is deleted as one batch by a pool of parallel workers.
+func emptyBucket(s3Client *s3.Client, bucketName string) error {
+	fmt.Println("Emptying bucket: ", bucketName)
+
+	batches := make(chan []types.ObjectIdentifier)
+	errs := make(chan error, purgeWorkers)
+	var deleted int64
+
+	var wg sync.WaitGroup
+	for i := 0; i < purgeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				resp, err := s3Client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+					Bucket: aws.String(bucketName),
+					Delete: &types.Delete{Objects: batch, Quiet: aws.Bool(true)},
+				})
+				if err == nil && len(resp.Errors) > 0 {
+					err = fmt.Errorf("failed to delete %s: %s", aws.ToString(resp.Errors[0].Key), aws.ToString(resp.Errors[0].Message))
+				}
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					continue
+				}
+				atomic.AddInt64(&deleted, int64(len(batch)))
+			}
+		}()
+	}
+
+	// Every page holds at most 1000 versions, the maximum of a DeleteObjects request
+	input := &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(bucketName),
+		MaxKeys: aws.Int32(1000),
+	}
+	var listErr error
+	for {
+		page, err := s3Client.ListObjectVersions(context.Background(), input)
+		if err != nil {
+			listErr = err
+			break
+		}
+
+		batch := []types.ObjectIdentifier{}
+		for _, v := range page.Versions {
+			batch = append(batch, types.ObjectIdentifier{Key: v.Key, VersionId: v.VersionId})
+		}
+		for _, m := range page.DeleteMarkers {
+			batch = append(batch, types.ObjectIdentifier{Key: m.Key, VersionId: m.VersionId})
+		}
+		if len(batch) > 0 {
+			batches <- batch
+		}
+
+		if !aws.ToBool(page.IsTruncated) {
+			break
+		}
+		input.KeyMarker = page.NextKeyMarker
+		input.VersionIdMarker = page.NextVersionIdMarker
+	}
+
+	close(batches)
+	wg.Wait()
+	close(errs)
+
+	if listErr != nil {
+		return listErr
+	}
+	if err := <-errs; err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleted %d object versions from bucket %s\n", deleted, bucketName)
+
 	return nil
 }
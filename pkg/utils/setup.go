@@ -3,21 +3,195 @@ package utils
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/release"
 )
 
 // Setup function sets up AWS and Helm resources needed for the application.
 // It takes the releaseName and namespace as input and returns an error if one occurs.
-func Setup(setupData SetupData) (SetupData, error) {
+// The setup ConfigMap is written with the installing phase before any resource is created, and every created resource
+// is recorded in its journal as the setup proceeds. Running the setup again for a release whose setup did not complete
+// resumes it from the first incomplete step, reusing its identifier and the resources it already created.
+// When the setup fails and rollbackOnFailure is set, the recorded resources are removed in reverse order.
+// Resources that are not removed are kept in the setup ConfigMap with the failed phase, so that a later
+// install can resume the setup or a later uninstall can remove them.
+func Setup(setupData SetupData, rollbackOnFailure bool) (SetupData, error) {
+
+	// Record the versions being installed so that later updates start from them
+	if setupData.ChartVersion == "" {
+		setupData.ChartVersion = DefaultChartVersion
+	}
+	if setupData.ImageTag == "" {
+		setupData.ImageTag = DefaultImageTag
+	}
+
+	journal := &Journal{}
 
-	// check if setup already exists
-	_, err := ReadConfigMap("zincobserve-setup", setupData.Namespace)
+	// check if setup already exists for this release
+	existing, err := ReadConfigMap(setupData.ReleaseName, setupData.Namespace)
 	if err == nil {
-		fmt.Println("Setup already exists")
+		if existing.Phase == SetupPhaseInstalled {
+			fmt.Println("Setup already exists")
+			return setupData, fmt.Errorf("setup already exists for release %s in namespace %s", setupData.ReleaseName, setupData.Namespace)
+		}
+		if existing.K8s != setupData.K8s {
+			return setupData, fmt.Errorf("an incomplete %s setup exists for release %s in namespace %s, run 'zctl --name=%s uninstall' before installing on %s", existing.K8s, setupData.ReleaseName, setupData.Namespace, setupData.ReleaseName, setupData.K8s)
+		}
+
+		err = checkResumeInputs(existing, setupData)
+		if err != nil {
+			return setupData, err
+		}
+
+		fmt.Printf("Resuming the %s setup of release %s (identifier %s)\n", existing.Phase, setupData.ReleaseName, existing.Identifier)
+		setupData.Identifier = existing.Identifier
+		setupData.Inputs = setupInputs(setupData)
+		journal.Entries = existing.Journal
+		if setupData.K8s == "gke" {
+			// The HMAC key created by the previous attempt is reused
+			setupData.S3AccessKey = existing.S3AccessKey
+			setupData.S3SecretKey = existing.S3SecretKey
+		}
+
+		setupData.Phase = SetupPhaseInstalling
+		setupData.Journal = journal.Entries
+		err = UpdateConfigMap(setupData)
+	} else {
+		setupData.Phase = SetupPhaseInstalling
+		setupData.Inputs = setupInputs(setupData)
+		err = CreateConfigMap(setupData)
+	}
+	if err != nil {
+		fmt.Println("error writing configmap: ", err)
 		return setupData, err
 	}
 
+	journal.Checkpoint = func(progress SetupData) error {
+		progress.Phase = SetupPhaseInstalling
+		return UpdateConfigMap(progress)
+	}
+
+	setupData, err = setupResources(setupData, journal)
+	if err != nil {
+		return setupData, failSetup(setupData, journal, rollbackOnFailure, err)
+	}
+
+	setupData.Phase = SetupPhaseInstalled
+	setupData.Journal = journal.Entries
+	setupData.Inputs = nil
+
+	err = UpdateConfigMap(setupData)
+	if err != nil {
+		fmt.Println("error updating configmap: ", err)
+		return setupData, err
+	}
+
+	return setupData, nil
+}
+
+// setupInputs returns the options of the install given by the setup data, before any resource is set up.
+func setupInputs(setupData SetupData) *SetupInputs {
+	inputs := &SetupInputs{
+		Region:       setupData.Region,
+		GCPProjectId: setupData.GCPProjectId,
+		BucketName:   setupData.BucketName,
+		IamRole:      setupData.IamRole,
+		AWSIdentity:  setupData.AWSIdentity,
+	}
+	if setupData.BucketOptions != nil {
+		options := *setupData.BucketOptions
+		inputs.BucketOptions = &options
+	}
+
+	return inputs
+}
+
+// bucketOptionFlags returns the bucket options as the values of the flags they are given with.
+func bucketOptionFlags(options *S3BucketOptions) map[string]string {
+	flags := map[string]string{}
+	if options == nil {
+		return flags
+	}
+
+	flags["--bucket_encryption"] = options.Encryption
+	flags["--kms_key"] = options.KMSKeyID
+	if options.CreateKMSKey {
+		flags["--kms_key"] = KMSKeyCreate
+	}
+	flags["--bucket_versioning"] = strconv.FormatBool(options.Versioning)
+
+	tags := []string{}
+	for key, value := range options.Tags {
+		tags = append(tags, key+"="+value)
+	}
+	sort.Strings(tags)
+	flags["--bucket_tags"] = strings.Join(tags, ",")
+
+	return flags
+}
+
+// checkResumeInputs refuses to resume the incomplete setup of a release with other options than the ones it was
+// started with, since the resources it already created would not match them. Setups started by older zctl versions
+// did not record their options and are resumed as they are.
+func checkResumeInputs(existing SetupData, setupData SetupData) error {
+	if existing.Inputs == nil {
+		return nil
+	}
+
+	recorded := *existing.Inputs
+	given := *setupInputs(setupData)
+	type option struct {
+		flag            string
+		recorded, given string
+	}
+	options := []option{
+		{"--region", recorded.Region, given.Region},
+		{"--gcp_project_id", recorded.GCPProjectId, given.GCPProjectId},
+		{"--bucket", recorded.BucketName, given.BucketName},
+		{"--iam_role", recorded.IamRole, given.IamRole},
+		{"--aws_identity", recorded.AWSIdentity, given.AWSIdentity},
+	}
+
+	recordedBucket, givenBucket := bucketOptionFlags(recorded.BucketOptions), bucketOptionFlags(given.BucketOptions)
+	for _, flag := range []string{"--bucket_encryption", "--kms_key", "--bucket_versioning", "--bucket_tags"} {
+		options = append(options, option{flag, recordedBucket[flag], givenBucket[flag]})
+	}
+
+	differences := []string{}
+	for _, option := range options {
+		if option.recorded != option.given {
+			differences = append(differences, fmt.Sprintf("%s was %q, not %q", option.flag, option.recorded, option.given))
+		}
+	}
+	if len(differences) > 0 {
+		return fmt.Errorf("the incomplete setup of release %s was started with other options: %s. Run 'zctl install' with the same options to resume it, or 'zctl --name=%s uninstall' to remove it", setupData.ReleaseName, strings.Join(differences, ", "), setupData.ReleaseName)
+	}
+
+	return nil
+}
+
+// setupResources creates the cloud resources and installs the Helm chart, recording every created resource in the journal.
+func setupResources(setupData SetupData, journal *Journal) (SetupData, error) {
 	if setupData.K8s == "eks" { ///////////////// Setup in EKS
-		bucket, role, clusterName, err := SetupAWS(setupData)
+		// A bucket or IAM role given for the install is adopted instead of created
+		setupData.AdoptedBucket = setupData.BucketName != ""
+		setupData.AdoptedIamRole = setupData.IamRole != ""
+
+		// The bucket created by zctl is located in the region of the installation, an adopted bucket wherever it is
+		setupData.BucketRegion = setupData.Region
+		if setupData.AdoptedBucket {
+			bucketRegion, err := GetS3BucketRegion(setupData.BucketName, setupData.Region)
+			if err != nil {
+				fmt.Println("error: ", err)
+				return setupData, err
+			}
+			setupData.BucketRegion = bucketRegion
+		}
+
+		bucket, role, clusterName, err := SetupAWS(setupData, journal)
 		if err != nil {
 			// Print an error message and terminate the program if an error occurs while setting up AWS resources.
 			fmt.Println("error: ", err)
@@ -27,17 +201,14 @@ func Setup(setupData SetupData) (SetupData, error) {
 		setupData.BucketName = bucket
 		setupData.IamRole = role
 		setupData.ClusterName = clusterName
-
-		accountId, err := GetAWSAccountID()
-		if err != nil {
-			fmt.Println("error: ", err)
-			return setupData, err
+		if entry, ok := journal.Find(ResourcePodIdentityAssociation); ok {
+			setupData.PodIdentityAssociation = entry.Name
+		}
+		if entry, ok := journal.Find(ResourceKMSKey); ok && setupData.BucketOptions != nil {
+			options := *setupData.BucketOptions
+			options.KMSKeyID = entry.Name
+			setupData.BucketOptions = &options
 		}
-		roleArn := "arn:aws:iam::" + accountId + ":role/" + setupData.IamRole
-
-		setupData.BucketName = bucket
-		setupData.IamRole = roleArn
-		setupData.ClusterName = clusterName
 
 	} else if setupData.K8s == "gke" { /////////////// Setup in GKE
 		// Setup GCP resources
@@ -46,7 +217,7 @@ func Setup(setupData SetupData) (SetupData, error) {
 		// 3. Create a bucket
 		// 4. Create HMAC keys
 
-		gcpData, err := SetupGCP(setupData)
+		gcpData, err := SetupGCP(setupData, journal)
 		if err != nil {
 			// Print an error message and terminate the program if an error occurs while setting up AWS resources.
 			fmt.Println("error: ", err)
@@ -65,7 +236,44 @@ func Setup(setupData SetupData) (SetupData, error) {
 		return setupData, errors.New("k8s type not supported")
 	}
 
-	err = SetupHelm(setupData)
+	context, err := CurrentKubeContext()
+	if err != nil {
+		fmt.Println("error: ", err)
+		return setupData, err
+	}
+
+	// The uninstall targets the release where and how it was installed
+	setupData.KubeContext = context
+	setupData.HelmDriver = HelmStorageDriver
+
+	if _, ok := journal.Find(ResourceHelmRelease); ok {
+		// A resumed setup keeps a release it already deployed, and installs again over one that did not deploy
+		rel, err := GetRelease(context, setupData.ReleaseName, setupData.Namespace)
+		if err == nil && rel.Info.Status == release.StatusDeployed {
+			fmt.Println("Helm release already installed: ", setupData.ReleaseName)
+			setupData.Revision = rel.Version
+			return setupData, nil
+		}
+		if err == nil {
+			err = Uninstall(context, setupData.ReleaseName, setupData.Namespace)
+			if err != nil {
+				fmt.Println("error: ", err)
+				return setupData, err
+			}
+		}
+	} else {
+		// Make sure an existing release is never removed by a rollback of this setup
+		if _, err := GetRelease(context, setupData.ReleaseName, setupData.Namespace); err == nil {
+			return setupData, fmt.Errorf("helm release %s already exists in namespace %s", setupData.ReleaseName, setupData.Namespace)
+		}
+
+		err = journal.Record(setupData, ResourceHelmRelease, setupData.ReleaseName, "")
+		if err != nil {
+			return setupData, err
+		}
+	}
+
+	setupData.Revision, err = SetupHelm(setupData)
 	if err != nil {
 		// Print an error message and terminate the program if an error occurs while setting up Helm resources.
 		fmt.Println("error: ", err)
@@ -75,36 +283,151 @@ func Setup(setupData SetupData) (SetupData, error) {
 	return setupData, nil
 }
 
-func Teardown(releaseName, namespace, region string) error {
+// failSetup handles a failed setup. When rollbackOnFailure is set, the resources recorded in the journal are removed,
+// and the setup ConfigMap is removed once none remain. Otherwise the remaining resources are kept in the setup ConfigMap
+// with the failed phase, so that the install can be resumed or uninstall can remove them.
+// It returns the error to report for the setup.
+func failSetup(setupData SetupData, journal *Journal, rollbackOnFailure bool, setupErr error) error {
+	if rollbackOnFailure && len(journal.Entries) > 0 {
+		fmt.Println("Setup failed, rolling back the created resources...")
+		err := journal.Rollback(setupData)
+		if err != nil {
+			setupErr = fmt.Errorf("%v; %v", setupErr, err)
+		}
+	}
 
-	// Get details from configmap
-	cmName := "zincobserve-setup"
-	// Read the configmap
-	cm, err := ReadConfigMap(cmName, namespace)
+	if len(journal.Entries) == 0 {
+		err := DeleteConfigMap(setupData.ReleaseName, setupData.Namespace)
+		if err != nil {
+			fmt.Println("error deleting configmap: ", err)
+		}
+		return setupErr
+	}
+
+	setupData.Phase = SetupPhaseFailed
+	setupData.Journal = journal.Entries
+
+	err := UpdateConfigMap(setupData)
 	if err != nil {
-		// Print an error message and terminate the program if an error occurs while setting up AWS resources.
-		fmt.Println("error reading configmap for release: "+releaseName+" in namespace: "+namespace+" : ", err)
-		return err
+		fmt.Println("error updating configmap: ", err)
+		return fmt.Errorf("%v; the created resources could not be recorded: %v", setupErr, err)
 	}
 
-	fmt.Println(cm)
+	return fmt.Errorf("%v; the created resources are recorded for release %s, run 'zctl install' again to resume the install or 'zctl --name=%s uninstall' to remove them", setupErr, setupData.ReleaseName, setupData.ReleaseName)
+}
 
-	if cm.K8s == "eks" {
-		err = TearDownAWS(cm, region)
+// Update upgrades an existing installation in place. The setup data is expected to be the one read from the
+// setup ConfigMap of the release with the requested changes (image tag, chart version, replicas, bucket options) applied on top.
+// The settings of an S3 bucket created by zctl are applied again, along with the inline policy of an IAM role created
+// by zctl, which grants the use of the KMS key of the bucket; the other cloud resources are not touched, and the
+// stored bucket, IAM role and HMAC keys are wired into the new chart values.
+// The ConfigMap is updated once the Helm upgrade succeeds.
+func Update(setupData SetupData) (SetupData, error) {
+	if setupData.ChartVersion == "" {
+		setupData.ChartVersion = DefaultChartVersion
+	}
+	if setupData.ImageTag == "" {
+		setupData.ImageTag = DefaultImageTag
+	}
+
+	setupData = withDefaultBucketOptions(setupData)
+	err := applyBucketSettings(setupData)
+	if err != nil {
+		fmt.Println("error: ", err)
+		return setupData, err
+	}
+
+	revision, err := UpgradeHelm(setupData)
+	if err != nil {
+		fmt.Println("error: ", err)
+		return setupData, err
+	}
+	setupData.Revision = revision
+
+	err = UpdateConfigMap(setupData)
+	if err != nil {
+		fmt.Println("error updating configmap: ", err)
+		return setupData, err
+	}
+
+	return setupData, nil
+}
+
+// withDefaultBucketOptions sets the default settings of an install on the bucket created by zctl on EKS when the setup
+// data records none, as for the installs made before the bucket settings were recorded.
+func withDefaultBucketOptions(setupData SetupData) SetupData {
+	if setupData.K8s == "eks" && !setupData.AdoptedBucket && setupData.BucketOptions == nil {
+		setupData.BucketOptions = &S3BucketOptions{Encryption: BucketEncryptionSSES3}
+	}
+
+	return setupData
+}
+
+// applyBucketSettings applies the recorded settings to the bucket created by zctl on EKS. The IAM role created by zctl
+// is granted the use of the KMS key before the bucket is encrypted with it. Adopted buckets are never modified.
+func applyBucketSettings(setupData SetupData) error {
+	if setupData.K8s != "eks" || setupData.AdoptedBucket || setupData.BucketOptions == nil {
+		return nil
+	}
+
+	if setupData.AdoptedIamRole {
+		if kmsKey := BucketKMSKey(setupData); kmsKey != "" {
+			fmt.Printf("The adopted IAM role %s is not modified, it must be granted the use of KMS key %s\n", setupData.IamRole, kmsKey)
+		}
+	} else {
+		err := PutIAMRoleInlinePolicy(setupData.IamRole, setupData.Region, "zo-s3", GetS3PolicyDocument(setupData.BucketName, BucketKMSKey(setupData)))
 		if err != nil {
-			// Print an error message and terminate the program if an error occurs while setting up AWS resources.
-			fmt.Println("error: ", err)
 			return err
 		}
-	} else if cm.K8s == "gke" {
-		// DeleteGCSBucket(cm) // We do not want to delete the data in the bucket
-		DeleteGCPServiceAccount(cm)
 	}
 
-	TearDownHelm(releaseName, namespace)
+	return ApplyS3BucketOptions(setupData.BucketName, S3BucketRegion(setupData), *setupData.BucketOptions, S3BucketTags(setupData))
+}
+
+// RollbackTo rolls the installation described by the setup data back to the given helm revision.
+// A revision of 0 rolls back to the revision preceding the current one.
+// The setup data snapshot recorded for the target revision is restored in the ConfigMap, and the settings of the
+// bucket created by zctl and the inline policy of the role are applied from it, so that the stored state, the deployed
+// values and the cloud resources stay in agreement.
+func RollbackTo(setupData SetupData, revision int) (SetupData, error) {
+	if revision == 0 {
+		history, err := HelmHistory(setupData)
+		if err != nil {
+			fmt.Println("error: ", err)
+			return setupData, err
+		}
+		if len(history) < 2 {
+			return setupData, errors.New("release has no previous revision to roll back to")
+		}
+		revision = history[len(history)-1].Version - 1
+	}
+
+	// Read the snapshot before touching the release, so that a rollback never leaves the state behind.
+	snapshot, err := ReadConfigMapRevision(setupData.ReleaseName, setupData.Namespace, revision)
+	if err != nil {
+		fmt.Println("error: ", err)
+		return setupData, err
+	}
+
+	newRevision, err := RollbackHelm(setupData, revision)
+	if err != nil {
+		return setupData, err
+	}
+	snapshot.Revision = newRevision
 
-	DeleteConfigMap(cmName, namespace)
+	// The bucket and the inline policy of the role get the settings of the revision back, as on update
+	snapshot = withDefaultBucketOptions(snapshot)
+	err = applyBucketSettings(snapshot)
+	if err != nil {
+		fmt.Println("error: ", err)
+		return setupData, err
+	}
 
-	return nil
+	err = UpdateConfigMap(snapshot)
+	if err != nil {
+		fmt.Println("error updating configmap: ", err)
+		return snapshot, err
+	}
 
+	return snapshot, nil
 }
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckResumeInputs(t *testing.T) {
+	started := SetupData{ReleaseName: "zo1", K8s: "eks", Region: "us-east-1", AWSIdentity: AWSIdentityIRSA}
+	withKMS := started
+	withKMS.BucketOptions = &S3BucketOptions{Encryption: BucketEncryptionSSEKMS, CreateKMSKey: true, Versioning: true, Tags: map[string]string{"team": "obs"}}
+	withS3 := started
+	withS3.BucketOptions = &S3BucketOptions{Encryption: BucketEncryptionSSES3}
+
+	tests := []struct {
+		name     string
+		existing SetupData
+		given    SetupData
+		wantErr  string
+	}{
+		{
+			name:     "same options",
+			existing: SetupData{Inputs: setupInputs(started)},
+			given:    started,
+		},
+		{
+			name:     "options not recorded",
+			existing: SetupData{},
+			given:    SetupData{ReleaseName: "zo1", BucketName: "b1"},
+		},
+		{
+			name:     "adopted bucket given on resume",
+			existing: SetupData{Inputs: setupInputs(started)},
+			given:    SetupData{ReleaseName: "zo1", K8s: "eks", Region: "us-east-1", AWSIdentity: AWSIdentityIRSA, BucketName: "b1"},
+			wantErr:  `--bucket was "", not "b1"`,
+		},
+		{
+			name:     "other identity and region",
+			existing: SetupData{Inputs: setupInputs(started)},
+			given:    SetupData{ReleaseName: "zo1", K8s: "eks", Region: "eu-west-1", AWSIdentity: AWSIdentityPodIdentity},
+			wantErr:  `--region was "us-east-1", not "eu-west-1", --aws_identity was "irsa", not "pod-identity"`,
+		},
+		{
+			name:     "same bucket options",
+			existing: SetupData{Inputs: setupInputs(withKMS)},
+			given:    withKMS,
+		},
+		{
+			name:     "bucket options not given on resume",
+			existing: SetupData{Inputs: setupInputs(withKMS)},
+			given:    started,
+			wantErr:  `--bucket_encryption was "sse-kms", not ""`,
+		},
+		{
+			name:     "other bucket options",
+			existing: SetupData{Inputs: setupInputs(withKMS)},
+			given:    withS3,
+			wantErr:  `--bucket_encryption was "sse-kms", not "sse-s3", --kms_key was "create", not "", --bucket_versioning was "true", not "false", --bucket_tags was "team=obs", not ""`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkResumeInputs(tt.existing, tt.given)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkResumeInputs() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkResumeInputs() error = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SetupDataAPIVersion is the schema version of the setup data written by this zctl.
+// Setup data written by older zctl versions is upgraded to it on read using setupDataMigrations.
+const SetupDataAPIVersion = "v3"
+
+// legacySetupDataAPIVersion is the schema version of setup data written before the schema was versioned.
+const legacySetupDataAPIVersion = "v1"
+
+// setupDataMigration upgrades a setup data blob from one schema version to the next.
+type setupDataMigration struct {
+	From    string
+	To      string
+	Migrate func(data map[string]interface{}) error
+}
+
+// setupDataMigrations is the chain of migrations applied, in order, to setup data read from a ConfigMap.
+// New schema versions are added by appending a migration from the previous latest version and bumping SetupDataAPIVersion.
+var setupDataMigrations = []setupDataMigration{
+	{
+		// v1 blobs are unversioned. The release name was stored under "name" (the README documented "release_name"),
+		// and the chart version and image tag were hard-coded when they were installed.
+		From: "v1",
+		To:   "v2",
+		Migrate: func(data map[string]interface{}) error {
+			if name, ok := data["name"]; ok {
+				if _, exists := data["release_name"]; !exists {
+					data["release_name"] = name
+				}
+				delete(data, "name")
+			}
+
+			if v, _ := data["chart_version"].(string); v == "" {
+				data["chart_version"] = "0.3.3"
+			}
+			if v, _ := data["image_tag"].(string); v == "" {
+				data["image_tag"] = "v0.3.2"
+			}
+
+			return nil
+		},
+	},
+	{
+		// v3 adds the install phase and journal. Setup data was only written by successful installs before.
+		From: "v2",
+		To:   "v3",
+		Migrate: func(data map[string]interface{}) error {
+			if v, _ := data["phase"].(string); v == "" {
+				data["phase"] = SetupPhaseInstalled
+			}
+
+			return nil
+		},
+	},
+}
+
+// decodeSetupData parses a setup data blob, upgrading it to SetupDataAPIVersion when it was written by an older zctl.
+// It fails when the blob was written by a newer zctl, since fields it does not know about would be lost.
+func decodeSetupData(value string) (SetupData, error) {
+	setupData := SetupData{}
+
+	data := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(value), &data); err != nil {
+		return setupData, err
+	}
+
+	version, _ := data["apiVersion"].(string)
+	if version == "" {
+		version = legacySetupDataAPIVersion
+	}
+
+	for _, migration := range setupDataMigrations {
+		if migration.From != version {
+			continue
+		}
+
+		if err := migration.Migrate(data); err != nil {
+			return setupData, fmt.Errorf("failed to migrate setup data from %s to %s: %w", migration.From, migration.To, err)
+		}
+		version = migration.To
+		data["apiVersion"] = version
+	}
+
+	if version != SetupDataAPIVersion {
+		return setupData, fmt.Errorf("setup data has apiVersion %s, which this zctl does not support (latest supported is %s). Please upgrade zctl", version, SetupDataAPIVersion)
+	}
+
+	migrated, err := json.Marshal(data)
+	if err != nil {
+		return setupData, err
+	}
+
+	err = json.Unmarshal(migrated, &setupData)
+	if err != nil {
+		return setupData, err
+	}
+
+	return setupData, nil
+}
+
+// marshalSetupData serializes the setup data for the ConfigMap in the current schema version, leaving out the credentials.
+func marshalSetupData(sData SetupData) (string, error) {
+	sData.APIVersion = SetupDataAPIVersion
+	sData.S3AccessKey = ""
+	sData.S3SecretKey = ""
+
+	dataBytes, err := json.Marshal(sData)
+	if err != nil {
+		return "", err
+	}
+
+	return string(dataBytes), nil
+}
+
+// setupDataVersion returns the schema version of a setup data blob without migrating it.
+func setupDataVersion(value string) (string, error) {
+	data := struct {
+		APIVersion string `json:"apiVersion"`
+	}{}
+	if err := json.Unmarshal([]byte(value), &data); err != nil {
+		return "", err
+	}
+
+	if data.APIVersion == "" {
+		return legacySetupDataAPIVersion, nil
+	}
+
+	return data.APIVersion, nil
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeSetupData(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    SetupData
+		wantErr string
+	}{
+		{
+			name:  "v1 blob renames name and fills the versions",
+			value: `{"name":"zo1","identifier":"1234","bucket_name":"b1"}`,
+			want: SetupData{
+				APIVersion:   SetupDataAPIVersion,
+				ReleaseName:  "zo1",
+				Identifier:   "1234",
+				BucketName:   "b1",
+				ChartVersion: "0.3.3",
+				ImageTag:     "v0.3.2",
+				Phase:        SetupPhaseInstalled,
+			},
+		},
+		{
+			name:  "v1 blob keeps an existing release name and versions",
+			value: `{"name":"old","release_name":"zo1","chart_version":"0.4.0","image_tag":"v0.4.0"}`,
+			want: SetupData{
+				APIVersion:   SetupDataAPIVersion,
+				ReleaseName:  "zo1",
+				ChartVersion: "0.4.0",
+				ImageTag:     "v0.4.0",
+				Phase:        SetupPhaseInstalled,
+			},
+		},
+		{
+			name:  "v2 blob gets the installed phase",
+			value: `{"apiVersion":"v2","release_name":"zo1","chart_version":"0.3.3","image_tag":"v0.3.2"}`,
+			want: SetupData{
+				APIVersion:   SetupDataAPIVersion,
+				ReleaseName:  "zo1",
+				ChartVersion: "0.3.3",
+				ImageTag:     "v0.3.2",
+				Phase:        SetupPhaseInstalled,
+			},
+		},
+		{
+			name:  "v3 blob is decoded as is",
+			value: `{"apiVersion":"v3","release_name":"zo1","phase":"failed"}`,
+			want: SetupData{
+				APIVersion:  SetupDataAPIVersion,
+				ReleaseName: "zo1",
+				Phase:       SetupPhaseFailed,
+			},
+		},
+		{
+			name:    "newer blob is refused",
+			value:   `{"apiVersion":"v99","release_name":"zo1"}`,
+			wantErr: "does not support",
+		},
+		{
+			name:    "invalid JSON",
+			value:   `{`,
+			wantErr: "unexpected end of JSON input",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeSetupData(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decodeSetupData() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeSetupData() error = %v", err)
+			}
+			if got.APIVersion != tt.want.APIVersion || got.ReleaseName != tt.want.ReleaseName || got.Identifier != tt.want.Identifier ||
+				got.BucketName != tt.want.BucketName || got.ChartVersion != tt.want.ChartVersion || got.ImageTag != tt.want.ImageTag ||
+				got.Phase != tt.want.Phase {
+				t.Errorf("decodeSetupData() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalSetupDataRoundTrip(t *testing.T) {
+	value, err := marshalSetupData(SetupData{ReleaseName: "zo1", S3AccessKey: "AKIAEXAMPLE", S3SecretKey: "secret-value"})
+	if err != nil {
+		t.Fatalf("marshalSetupData() error = %v", err)
+	}
+	if strings.Contains(value, "AKIAEXAMPLE") || strings.Contains(value, "secret-value") {
+		t.Errorf("marshalSetupData() = %s, want no credentials", value)
+	}
+
+	version, err := setupDataVersion(value)
+	if err != nil || version != SetupDataAPIVersion {
+		t.Errorf("setupDataVersion() = %s, %v, want %s", version, err, SetupDataAPIVersion)
+	}
+
+	got, err := decodeSetupData(value)
+	if err != nil || got.ReleaseName != "zo1" {
+		t.Errorf("decodeSetupData() = %+v, %v, want release zo1", got, err)
+	}
+}
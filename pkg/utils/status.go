@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// zincObserveComponents are the ZincObserve components deployed by the chart.
+var zincObserveComponents = []string{"ingester", "querier", "router", "compactor", "alertmanager"}
+
+// InstallationStatus is the health report of a ZincObserve installation.
+type InstallationStatus struct {
+	Name           string            `json:"name"`
+	Namespace      string            `json:"namespace"`
+	ReleaseStatus  string            `json:"release_status"`
+	Revision       int               `json:"revision"`
+	Components     []ComponentStatus `json:"components"`
+	Volumes        []VolumeStatus    `json:"volumes"`
+	CloudResources []ResourceStatus  `json:"cloud_resources"`
+	Healthy        bool              `json:"healthy"`
+}
+
+// ComponentStatus is the readiness of a Deployment or StatefulSet of the release.
+type ComponentStatus struct {
+	Component string `json:"component"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Ready     int32  `json:"ready"`
+	Desired   int32  `json:"desired"`
+	Healthy   bool   `json:"healthy"`
+}
+
+// VolumeStatus is the binding of a PersistentVolumeClaim of a StatefulSet of the release.
+type VolumeStatus struct {
+	Name    string `json:"name"`
+	Phase   string `json:"phase"`
+	Healthy bool   `json:"healthy"`
+}
+
+// ResourceStatus is the existence of a cloud resource recorded in the setup data.
+type ResourceStatus struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Exists  bool   `json:"exists"`
+	Error   string `json:"error,omitempty"`
+	Healthy bool   `json:"healthy"`
+}
+
+// manifestObject holds the fields of a rendered manifest needed to find the workloads of a release.
+type manifestObject struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+	Spec struct {
+		VolumeClaimTemplates []struct {
+			Metadata struct {
+				Name string `yaml:"name"`
+			} `yaml:"metadata"`
+		} `yaml:"volumeClaimTemplates"`
+	} `yaml:"spec"`
+}
+
+// GetInstallationStatus builds the health report of the installation described by the setup data:
+// the Helm release status, the readiness of every Deployment and StatefulSet of the release,
+// the binding of their PersistentVolumeClaims and the existence of the recorded cloud resources.
+func GetInstallationStatus(setupData SetupData) (InstallationStatus, error) {
+	report := InstallationStatus{
+		Name:      setupData.ReleaseName,
+		Namespace: setupData.Namespace,
+	}
+
+	kubeContext, err := CurrentKubeContext()
+	if err != nil {
+		return report, err
+	}
+
+	clientset, err := Client(kubeContext)
+	if err != nil {
+		return report, err
+	}
+
+	rel, err := Status(kubeContext, setupData.ReleaseName, setupData.Namespace)
+	if err != nil {
+		// A missing release is reported, the cloud resources are still checked
+		report.ReleaseStatus = "error: " + err.Error()
+	} else {
+		report.ReleaseStatus = rel.Info.Status.String()
+		report.Revision = rel.Version
+
+		for _, manifest := range releaseutil.SplitManifests(rel.Manifest) {
+			obj := manifestObject{}
+			if err := yaml.Unmarshal([]byte(manifest), &obj); err != nil {
+				return report, err
+			}
+
+			switch obj.Kind {
+			case "Deployment":
+				component := ComponentStatus{Component: componentOf(obj.Metadata.Name), Kind: obj.Kind, Name: obj.Metadata.Name}
+				deployment, err := clientset.AppsV1().Deployments(setupData.Namespace).Get(context.Background(), obj.Metadata.Name, metav1.GetOptions{})
+				if err == nil {
+					component.Ready = deployment.Status.ReadyReplicas
+					if deployment.Spec.Replicas != nil {
+						component.Desired = *deployment.Spec.Replicas
+					}
+				} else if !apierrors.IsNotFound(err) {
+					return report, err
+				}
+				component.Healthy = err == nil && component.Ready >= component.Desired
+				report.Components = append(report.Components, component)
+
+			case "StatefulSet":
+				component := ComponentStatus{Component: componentOf(obj.Metadata.Name), Kind: obj.Kind, Name: obj.Metadata.Name}
+				statefulSet, err := clientset.AppsV1().StatefulSets(setupData.Namespace).Get(context.Background(), obj.Metadata.Name, metav1.GetOptions{})
+				if err == nil {
+					component.Ready = statefulSet.Status.ReadyReplicas
+					if statefulSet.Spec.Replicas != nil {
+						component.Desired = *statefulSet.Spec.Replicas
+					}
+				} else if !apierrors.IsNotFound(err) {
+					return report, err
+				}
+				component.Healthy = err == nil && component.Ready >= component.Desired
+				report.Components = append(report.Components, component)
+
+				// PersistentVolumeClaims of a StatefulSet are named <template>-<statefulset>-<ordinal>
+				for _, template := range obj.Spec.VolumeClaimTemplates {
+					for ordinal := int32(0); ordinal < component.Desired; ordinal++ {
+						volume := VolumeStatus{Name: fmt.Sprintf("%s-%s-%d", template.Metadata.Name, obj.Metadata.Name, ordinal), Phase: "Missing"}
+						pvc, err := clientset.CoreV1().PersistentVolumeClaims(setupData.Namespace).Get(context.Background(), volume.Name, metav1.GetOptions{})
+						if err == nil {
+							volume.Phase = string(pvc.Status.Phase)
+						} else if !apierrors.IsNotFound(err) {
+							return report, err
+						}
+						volume.Healthy = volume.Phase == string(corev1.ClaimBound)
+						report.Volumes = append(report.Volumes, volume)
+					}
+				}
+			}
+		}
+	}
+
+	report.CloudResources = cloudResourceStatuses(setupData)
+
+	report.Healthy = rel != nil && report.ReleaseStatus == "deployed"
+	for _, c := range report.Components {
+		report.Healthy = report.Healthy && c.Healthy
+	}
+	for _, v := range report.Volumes {
+		report.Healthy = report.Healthy && v.Healthy
+	}
+	for _, r := range report.CloudResources {
+		report.Healthy = report.Healthy && r.Healthy
+	}
+
+	return report, nil
+}
+
+// cloudResourceStatuses checks that the cloud resources recorded in the setup data still exist.
+func cloudResourceStatuses(setupData SetupData) []ResourceStatus {
+	statuses := []ResourceStatus{}
+
+	check := func(kind, name string, exists func() (bool, error)) {
+		status := ResourceStatus{Kind: kind, Name: name}
+		found, err := exists()
+		if err != nil {
+			status.Error = err.Error()
+		}
+		status.Exists = found
+		status.Healthy = found && err == nil
+		statuses = append(statuses, status)
+	}
+
+	if setupData.K8s == "eks" {
+		check("s3-bucket", setupData.BucketName, func() (bool, error) {
+			return S3BucketExists(setupData.BucketName, S3BucketRegion(setupData))
+		})
+		check("iam-role", setupData.IamRole, func() (bool, error) {
+			return IAMRoleExists(setupData.IamRole, setupData.Region)
+		})
+		if setupData.PodIdentityAssociation != "" {
+			check("pod-identity-association", setupData.PodIdentityAssociation, func() (bool, error) {
+				return PodIdentityAssociationExists(setupData.PodIdentityAssociation)
+			})
+		}
+	} else if setupData.K8s == "gke" {
+		check("gcs-bucket", setupData.BucketName, func() (bool, error) {
+			return GCSBucketExists(setupData.BucketName)
+		})
+		check("gcp-service-account", setupData.ServiceAccount, func() (bool, error) {
+			return GCPServiceAccountExists(setupData.GCPProjectId, setupData.ServiceAccount)
+		})
+	}
+
+	return statuses
+}
+
+// componentOf returns the ZincObserve component a workload of the release belongs to, or its name for other workloads (e.g. etcd, minio).
+func componentOf(workloadName string) string {
+	for _, component := range zincObserveComponents {
+		if strings.HasSuffix(workloadName, "-"+component) {
+			return component
+		}
+	}
+
+	return workloadName
+}
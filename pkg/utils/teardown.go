@@ -0,0 +1,281 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// Statuses of the steps of a teardown.
+const (
+	TeardownStepOK      = "ok"      // the step succeeded, or what it removes was already gone
+	TeardownStepFailed  = "failed"  // the step failed, the uninstall can be retried
+	TeardownStepSkipped = "skipped" // the step depends on a step that failed
+)
+
+// TeardownReport is the result of the steps of an uninstall.
+type TeardownReport struct {
+	Name      string         `json:"name"`
+	Namespace string         `json:"namespace"`
+	Steps     []TeardownStep `json:"steps"`
+	Failed    bool           `json:"failed"` // a step failed
+}
+
+// TeardownStep is the result of one step of a TeardownReport.
+type TeardownStep struct {
+	Step   string `json:"step"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"` // why the step was skipped
+	Error  string `json:"error,omitempty"`
+}
+
+// run records a step. When skipReason is set, the step is skipped because it depends on a step that failed.
+// It returns whether the step succeeded.
+func (r *TeardownReport) run(step, skipReason string, action func() error) bool {
+	result := TeardownStep{Step: step, Status: TeardownStepOK}
+
+	if skipReason != "" {
+		result.Status = TeardownStepSkipped
+		result.Reason = skipReason
+	} else if err := action(); err != nil {
+		fmt.Printf("error: %s: %v\n", step, err)
+		result.Status = TeardownStepFailed
+		result.Error = err.Error()
+		r.Failed = true
+	}
+
+	r.Steps = append(r.Steps, result)
+
+	return result.Status == TeardownStepOK
+}
+
+// failures returns the number of steps that failed.
+func (r *TeardownReport) failures() int {
+	failed := 0
+	for _, step := range r.Steps {
+		if step.Status == TeardownStepFailed {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// Teardown removes an installation: its cloud resources, its Helm release and its setup ConfigMap.
+// The bucket is kept unless purgeData is set, in which case the bucket is emptied and deleted (adopted buckets are kept)
+// and the PersistentVolumeClaims of the release (ingester, etcd, MinIO) are deleted. A KMS key created by zctl for the
+// bucket is scheduled for deletion along with its data; it is kept with a kept bucket, whose data it encrypts.
+//
+// Teardown is best effort: every step runs unless it depends on a step that failed, and resources that are already
+// gone count as removed. The setup ConfigMap is only deleted when every other step succeeded, so that the uninstall
+// can be retried. The report lists the result of every step; an error is returned when a step failed.
+func Teardown(releaseName, namespace, region string, purgeData bool) (TeardownReport, error) {
+	report := TeardownReport{
+		Name:      releaseName,
+		Namespace: namespace,
+		Steps:     []TeardownStep{},
+	}
+
+	// Read the configmap of the release
+	var cm SetupData
+	ok := report.run("read setup state", "", func() (err error) {
+		cm, err = ReadConfigMap(releaseName, namespace)
+		return err
+	})
+	if !ok {
+		return report, fmt.Errorf("no setup found for release %s in namespace %s", releaseName, namespace)
+	}
+
+	// Nothing is removed from a cluster other than the one the release was installed on
+	ok = report.run("check kube context", "", func() (err error) {
+		cm.KubeContext, err = teardownKubeContext(cm)
+		return err
+	})
+	if !ok {
+		return report, fmt.Errorf("refusing to uninstall release %s from the current kube context", releaseName)
+	}
+
+	// The claims are found in the release manifest, which is removed with the release
+	claimsFound := true
+	if purgeData {
+		claimsFound = report.run("find persistent volume claims", "", func() error {
+			return findTeardownVolumeClaims(&cm)
+		})
+	}
+
+	// A failed setup is torn down by removing the resources recorded in its journal
+	if cm.Phase != SetupPhaseInstalled {
+		if purgeData {
+			report.run("empty buckets", "", func() error {
+				return emptyJournalBuckets(cm.Journal)
+			})
+		}
+
+		// A bucket that could not be emptied fails to be deleted and stays in the journal
+		journal := &Journal{Entries: cm.Journal}
+		rolledBack := report.run("roll back journal", "", func() error {
+			return journal.Rollback(cm)
+		})
+
+		if purgeData {
+			skip := claimsSkipReason(claimsFound)
+			if skip == "" && !rolledBack {
+				skip = "the journal was not rolled back"
+			}
+			report.run("delete persistent volume claims", skip, func() error {
+				return DeleteVolumeClaims(cm.Namespace, *cm.VolumeClaims)
+			})
+		}
+
+		// The resources that could not be rolled back are kept in the journal
+		return teardownSetupState(report, cm, func() error {
+			cm.Journal = journal.Entries
+			return updateCurrentSetupData(cm)
+		})
+	}
+
+	if cm.K8s == "eks" {
+		report.run("delete AWS resources", "", func() error {
+			return TearDownAWS(cm, region)
+		})
+	} else if cm.K8s == "gke" {
+		// DeleteGCSBucket(cm) // We do not want to delete the data in the bucket, unless purgeData is set
+		report.run("delete GCP resources", "", func() error {
+			return TearDownGCP(cm)
+		})
+	}
+
+	uninstalled := report.run("uninstall helm release", "", func() error {
+		return TearDownHelm(cm)
+	})
+
+	if kmsKey := CreatedKMSKey(cm); cm.K8s == "eks" && kmsKey != "" && !purgeData {
+		fmt.Printf("Keeping KMS key %s, the data of the kept bucket is encrypted with it\n", kmsKey)
+	}
+
+	// The data is purged once the release is gone, so that nothing writes to it anymore
+	if purgeData {
+		skip := ""
+		if !uninstalled {
+			skip = "the helm release was not uninstalled"
+		}
+		purged := report.run("purge bucket", skip, func() error {
+			return PurgeBucket(cm)
+		})
+
+		// The data of the bucket is encrypted with the KMS key created by zctl, which is only deleted with the data
+		if kmsKey := CreatedKMSKey(cm); cm.K8s == "eks" && kmsKey != "" {
+			skip = ""
+			if !purged {
+				skip = "the bucket was not purged"
+			}
+			report.run("delete KMS key", skip, func() error {
+				return DeleteKMSKey(kmsKey, cm.Region)
+			})
+		}
+
+		skip = claimsSkipReason(claimsFound)
+		if skip == "" && !uninstalled {
+			skip = "the helm release was not uninstalled"
+		}
+		report.run("delete persistent volume claims", skip, func() error {
+			return DeleteVolumeClaims(cm.Namespace, *cm.VolumeClaims)
+		})
+	}
+
+	return teardownSetupState(report, cm, nil)
+}
+
+// teardownSetupState deletes the setup ConfigMap when every step succeeded. Otherwise the ConfigMap is kept, and
+// updated with keep when set, so that the uninstall can be retried.
+func teardownSetupState(report TeardownReport, cm SetupData, keep func() error) (TeardownReport, error) {
+	if report.Failed {
+		report.run("delete setup state", "a step failed, the state is kept to retry the uninstall", nil)
+		if keep != nil {
+			if err := keep(); err != nil {
+				fmt.Println("error updating the setup state: ", err)
+			}
+		}
+	} else {
+		report.run("delete setup state", "", func() error {
+			return DeleteConfigMap(cm.ReleaseName, cm.Namespace)
+		})
+	}
+
+	if report.Failed {
+		return report, fmt.Errorf("%d step(s) of the uninstall of release %s failed", report.failures(), cm.ReleaseName)
+	}
+
+	return report, nil
+}
+
+// teardownKubeContext returns the kube context to uninstall the release from: the context recorded at install time,
+// or the current context when the kubeconfig does not have it (e.g. on another machine). It fails when the current
+// kube context points at a different cluster than the one the release was installed on.
+func teardownKubeContext(cm SetupData) (string, error) {
+	if cm.K8s == "eks" && cm.ClusterName != "" {
+		clusterName, err := GetCurrentEKSClusterName(cm.Region)
+		if err != nil {
+			return "", fmt.Errorf("failed to find the EKS cluster of the current kube context: %w", err)
+		}
+		if clusterName != cm.ClusterName {
+			return "", fmt.Errorf("the current kube context points at EKS cluster %s, but release %s was installed on cluster %s", clusterName, cm.ReleaseName, cm.ClusterName)
+		}
+	}
+
+	currentServer, err := GetCurrentKubeContextAPIEndpoint()
+	if err != nil {
+		return "", err
+	}
+
+	if cm.KubeContext != "" {
+		raw, err := Kubeconfig()
+		if err != nil {
+			return "", err
+		}
+		if context, ok := raw.Contexts[cm.KubeContext]; ok {
+			cluster, ok := raw.Clusters[context.Cluster]
+			if !ok || cluster.Server != currentServer {
+				return "", fmt.Errorf("the current kube context points at %s, but release %s was installed with kube context %s, which points at another cluster", currentServer, cm.ReleaseName, cm.KubeContext)
+			}
+			return cm.KubeContext, nil
+		}
+		fmt.Printf("Kube context %s recorded at install time not found, using the current kube context\n", cm.KubeContext)
+	}
+
+	return KubeContextForCluster(currentServer)
+}
+
+// findTeardownVolumeClaims finds the PersistentVolumeClaims of the release and records them in the setup state, so
+// that a retried uninstall can still delete them once the release is gone. Claims recorded by an earlier attempt
+// are reused; a release that no longer exists has no claims to find.
+func findTeardownVolumeClaims(cm *SetupData) error {
+	if cm.VolumeClaims != nil {
+		return nil
+	}
+
+	claims, err := FindVolumeClaims(*cm)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		fmt.Println("Release not found, no PersistentVolumeClaims to delete: ", cm.ReleaseName)
+		cm.VolumeClaims = &VolumeClaims{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cm.VolumeClaims = &claims
+
+	// The claims are not part of the deployed revision, its snapshot is left as it was
+	return updateCurrentSetupData(*cm)
+}
+
+// claimsSkipReason returns why the PersistentVolumeClaims cannot be deleted when they were not found.
+func claimsSkipReason(claimsFound bool) string {
+	if !claimsFound {
+		return "the persistent volume claims were not found"
+	}
+
+	return ""
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTeardownReportRun(t *testing.T) {
+	report := TeardownReport{Name: "zo1", Namespace: "zo"}
+	calls := 0
+	action := func(err error) func() error {
+		return func() error {
+			calls++
+			return err
+		}
+	}
+
+	results := []bool{
+		report.run("uninstall helm release", "", action(nil)),
+		report.run("delete bucket", "", action(errors.New("access denied"))),
+		report.run("delete kms key", "the bucket was not deleted", action(nil)),
+		report.run("delete iam role", "", action(errors.New("throttled"))),
+	}
+
+	if want := []bool{true, false, false, false}; !reflect.DeepEqual(results, want) {
+		t.Errorf("run() = %v, want %v", results, want)
+	}
+	if calls != 3 {
+		t.Errorf("run() called %d actions, want 3: a skipped step does not run", calls)
+	}
+	if !report.Failed {
+		t.Error("report.Failed = false, want true")
+	}
+	if got := report.failures(); got != 2 {
+		t.Errorf("failures() = %d, want 2: skipped steps do not count", got)
+	}
+
+	want := []TeardownStep{
+		{Step: "uninstall helm release", Status: TeardownStepOK},
+		{Step: "delete bucket", Status: TeardownStepFailed, Error: "access denied"},
+		{Step: "delete kms key", Status: TeardownStepSkipped, Reason: "the bucket was not deleted"},
+		{Step: "delete iam role", Status: TeardownStepFailed, Error: "throttled"},
+	}
+	if !reflect.DeepEqual(report.Steps, want) {
+		t.Errorf("report.Steps = %+v, want %+v", report.Steps, want)
+	}
+}
+
+func TestTeardownSetupStateKeptOnFailure(t *testing.T) {
+	report := TeardownReport{Name: "zo1", Namespace: "zo"}
+	report.run("delete bucket", "", func() error { return errors.New("access denied") })
+
+	kept := false
+	got, err := teardownSetupState(report, SetupData{ReleaseName: "zo1", Namespace: "zo"}, func() error {
+		kept = true
+		return nil
+	})
+	if err == nil || err.Error() != "1 step(s) of the uninstall of release zo1 failed" {
+		t.Errorf("teardownSetupState() error = %v", err)
+	}
+	if !kept {
+		t.Error("teardownSetupState() did not keep the setup state")
+	}
+
+	last := got.Steps[len(got.Steps)-1]
+	if last.Step != "delete setup state" || last.Status != TeardownStepSkipped {
+		t.Errorf("teardownSetupState() last step = %+v, want a skipped delete setup state", last)
+	}
+}
+
+func TestClaimsSkipReason(t *testing.T) {
+	if got := claimsSkipReason(true); got != "" {
+		t.Errorf("claimsSkipReason(true) = %q, want none", got)
+	}
+	if got := claimsSkipReason(false); got == "" {
+		t.Error("claimsSkipReason(false) = \"\", want a reason")
+	}
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Placeholders used for the cloud-derived fields of a rendered template that were not given explicitly.
+const (
+	PlaceholderBucketName  = "<bucket-name>"
+	PlaceholderIamRoleArn  = "<iam-role-arn>"
+	PlaceholderAccessKey   = "<s3-access-key>"
+	PlaceholderSecretKey   = "<s3-secret-key>"
+	PlaceholderS3ServerURL = "<s3-server-url>"
+)
+
+// TemplateOutput holds the effective chart values and the rendered manifests of a release.
+type TemplateOutput struct {
+	Values    string
+	Manifests string
+}
+
+// RenderTemplate renders the effective values and the manifests of the chart for the setup data without contacting
+// the cluster or the cloud provider. The chart is downloaded, or loaded from chartPath when it is set.
+// Cloud-derived fields that are not set in the setup data (bucket name, IAM role ARN, credentials) are replaced by placeholders.
+func RenderTemplate(setupData SetupData, chartPath string) (TemplateOutput, error) {
+	output := TemplateOutput{}
+
+	if setupData.ChartVersion == "" {
+		setupData.ChartVersion = DefaultChartVersion
+	}
+	if setupData.ImageTag == "" {
+		setupData.ImageTag = DefaultImageTag
+	}
+
+	placeholder := func(value *string, p string) {
+		if *value == "" {
+			*value = p
+		}
+	}
+
+	switch setupData.K8s {
+	case "eks":
+		placeholder(&setupData.BucketName, PlaceholderBucketName)
+		placeholder(&setupData.IamRole, PlaceholderIamRoleArn)
+	case "gke":
+		placeholder(&setupData.BucketName, PlaceholderBucketName)
+		placeholder(&setupData.S3AccessKey, PlaceholderAccessKey)
+		placeholder(&setupData.S3SecretKey, PlaceholderSecretKey)
+	case "plain":
+		if !setupData.InstallMinIO {
+			placeholder(&setupData.BucketName, PlaceholderBucketName)
+			placeholder(&setupData.S3ServerURL, PlaceholderS3ServerURL)
+			placeholder(&setupData.S3AccessKey, PlaceholderAccessKey)
+			placeholder(&setupData.S3SecretKey, PlaceholderSecretKey)
+		}
+	default:
+		return output, fmt.Errorf("invalid k8s provider. Valid values are: eks, gke, plain")
+	}
+
+	values, rel, err := RenderHelmChart(setupData, chartPath)
+	if err != nil {
+		return output, err
+	}
+
+	valuesYaml, err := yaml.Marshal(values)
+	if err != nil {
+		return output, err
+	}
+
+	output.Values = string(valuesYaml)
+	output.Manifests = RenderedManifests(rel)
+
+	return output, nil
+}
+
+// CommentOut prefixes every line of the text with "# ", so that it can be emitted in a YAML stream without being parsed.
+func CommentOut(text string) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("# "+line, " ")
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommentOut(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"single line", "a: 1", "# a: 1\n"},
+		{"trailing newline", "a: 1\n", "# a: 1\n"},
+		{"several lines", "a:\n  b: 1\n", "# a:\n#   b: 1\n"},
+		{"empty lines have no trailing space", "a: 1\n\nb: 2\n", "# a: 1\n#\n# b: 2\n"},
+		{"empty text", "", "#\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CommentOut(tt.text); got != tt.want {
+				t.Errorf("CommentOut(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// writeTestChart writes a minimal chart that renders the values set up by zctl, and returns its path.
+func writeTestChart(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: zincobserve\nversion: 0.3.3\n",
+		"values.yaml": "serviceAccount:\n  annotations: {}\n",
+		"templates/values.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+data:
+  bucket: {{ .Values.config.ZO_S3_BUCKET_NAME | quote }}
+  role: {{ index .Values.serviceAccount.annotations "eks.amazonaws.com/role-arn" | default "" | quote }}
+  access_key: {{ .Values.auth.ZO_S3_ACCESS_KEY | default "" | quote }}
+  server_url: {{ .Values.config.ZO_S3_SERVER_URL | default "" | quote }}
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestRenderTemplatePlaceholders(t *testing.T) {
+	chartPath := writeTestChart(t)
+
+	tests := []struct {
+		name      string
+		setupData SetupData
+		want      []string
+		notWant   []string
+	}{
+		{
+			name:      "eks",
+			setupData: SetupData{ReleaseName: "zo1", Namespace: "zo", K8s: "eks"},
+			want:      []string{`bucket: "<bucket-name>"`, `role: "<iam-role-arn>"`},
+		},
+		{
+			name:      "eks with a given bucket",
+			setupData: SetupData{ReleaseName: "zo1", Namespace: "zo", K8s: "eks", BucketName: "b1"},
+			want:      []string{`bucket: "b1"`, `role: "<iam-role-arn>"`},
+		},
+		{
+			name:      "gke",
+			setupData: SetupData{ReleaseName: "zo1", Namespace: "zo", K8s: "gke"},
+			want:      []string{`bucket: "<bucket-name>"`, `access_key: "<s3-access-key>"`},
+		},
+		{
+			name:      "plain without minio",
+			setupData: SetupData{ReleaseName: "zo1", Namespace: "zo", K8s: "plain"},
+			want:      []string{`access_key: "<s3-access-key>"`, `server_url: "<s3-server-url>"`},
+		},
+		{
+			name:      "plain with minio",
+			setupData: SetupData{ReleaseName: "zo1", Namespace: "zo", K8s: "plain", InstallMinIO: true},
+			notWant:   []string{"<s3-access-key>", "<s3-server-url>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := RenderTemplate(tt.setupData, chartPath)
+			if err != nil {
+				t.Fatalf("RenderTemplate() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(output.Manifests, want) {
+					t.Errorf("RenderTemplate() manifests do not contain %q:\n%s", want, output.Manifests)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(output.Manifests, notWant) {
+					t.Errorf("RenderTemplate() manifests contain %q:\n%s", notWant, output.Manifests)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderTemplateInvalidProvider(t *testing.T) {
+	if _, err := RenderTemplate(SetupData{ReleaseName: "zo1", K8s: "aks"}, writeTestChart(t)); err == nil {
+		t.Error("RenderTemplate() with k8s aks want an error")
+	}
+}
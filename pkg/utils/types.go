@@ -58,11 +58,11 @@ type SecurityContext struct {
 }
 
 type ReplicaCount struct {
-	Ingester     int `yaml:"ingester"`
-	Querier      int `yaml:"querier"`
-	Router       int `yaml:"router"`
-	Alertmanager int `yaml:"alertmanager"`
-	Compactor    int `yaml:"compactor"`
+	Ingester     int `yaml:"ingester" json:"ingester,omitempty"`
+	Querier      int `yaml:"querier" json:"querier,omitempty"`
+	Router       int `yaml:"router" json:"router,omitempty"`
+	Alertmanager int `yaml:"alertmanager" json:"alertmanager,omitempty"`
+	Compactor    int `yaml:"compactor" json:"compactor,omitempty"`
 }
 
 type Auth struct {
@@ -268,20 +268,62 @@ type Statement struct {
 	Resource []string `json:"Resource"`
 }
 
+// S3BucketOptions are the settings of the S3 bucket created by zctl on EKS. They are applied when the bucket is
+// created and again by every update, along with S3 Block Public Access, enforced bucket owner object ownership and
+// the standard tags of S3BucketTags. They are never applied to an adopted bucket.
+// The KMS key of a bucket encrypted with SSE-KMS is either given for the install or created by zctl.
+type S3BucketOptions struct {
+	Encryption   string            `json:"encryption"`               // default encryption, sse-s3 or sse-kms
+	KMSKeyID     string            `json:"kms_key_id,omitempty"`     // KMS key of the sse-kms encryption
+	CreateKMSKey bool              `json:"create_kms_key,omitempty"` // the KMS key is created by zctl, and deleted when the bucket is purged
+	Versioning   bool              `json:"versioning"`               // object versioning is enabled
+	Tags         map[string]string `json:"tags,omitempty"`           // tags added to the standard tags
+}
+
+// SetupInputs are the options of an install that determine the cloud resources it creates or adopts. They are
+// recorded when the install starts, so that an incomplete install is only resumed with the same options.
+type SetupInputs struct {
+	Region        string           `json:"region,omitempty"`
+	GCPProjectId  string           `json:"gcp_project_id,omitempty"`
+	BucketName    string           `json:"bucket_name,omitempty"`    // bucket given for the install, adopted instead of created
+	IamRole       string           `json:"iam_role,omitempty"`       // IAM role given for the install, adopted instead of created
+	AWSIdentity   string           `json:"aws_identity,omitempty"`   // irsa or pod-identity
+	BucketOptions *S3BucketOptions `json:"bucket_options,omitempty"` // settings of the bucket created on EKS, as given
+}
+
 type SetupData struct {
-	Identifier      string `json:"identifier"`   // unique identifier generated randomly to avoid conflicts
-	BucketName      string `json:"bucket_name"`  // s3 bucket name
-	ReleaseName     string `json:"name"` // helm release name
-	IamRole         string `json:"iam_role"`     // role name
-	K8s             string `json:"k8s"`          // k8s cluster name eks, gke, plain
-	S3AccessKey     string `json:"s3_access_key"`
-	S3SecretKey     string `json:"s3_secret_key"`
-	Namespace       string `json:"namespace"`
-	Region          string `json:"region"`
-	GCPProjectId    string `json:"gcp_project_id"`
-	ClusterName     string `json:"cluster_name"`
-	ServiceAccount  string `json:"service_account"`
-	InstallMinIO    bool   `json:"install_minio"`
-	StorageProvider string `json:"storage_provider"`
-	S3ServerURL     string `json:"s3_server_url"`
+	APIVersion             string           `json:"apiVersion"`   // schema version of the setup data, see SetupDataAPIVersion
+	Identifier             string           `json:"identifier"`   // unique identifier generated randomly to avoid conflicts
+	BucketName             string           `json:"bucket_name"`  // s3 bucket name
+	ReleaseName            string           `json:"release_name"` // helm release name
+	IamRole                string           `json:"iam_role"`     // role name
+	K8s                    string           `json:"k8s"`          // k8s cluster name eks, gke, plain
+	S3AccessKey            string           `json:"s3_access_key"`
+	S3SecretKey            string           `json:"s3_secret_key"`
+	Namespace              string           `json:"namespace"`
+	Region                 string           `json:"region"`
+	GCPProjectId           string           `json:"gcp_project_id"`
+	ClusterName            string           `json:"cluster_name"`
+	ServiceAccount         string           `json:"service_account"`
+	InstallMinIO           bool             `json:"install_minio"`
+	StorageProvider        string           `json:"storage_provider"`
+	S3ServerURL            string           `json:"s3_server_url"`
+	ChartVersion           string           `json:"chart_version"`                      // helm chart version, defaults to DefaultChartVersion
+	ImageTag               string           `json:"image_tag"`                          // zincobserve image tag, defaults to DefaultImageTag
+	Replicas               ReplicaCount     `json:"replicas"`                           // replica counts overriding the chart defaults
+	Revision               int              `json:"revision"`                           // helm revision deployed with this setup data
+	HMACAccessID           string           `json:"hmac_access_id,omitempty"`           // access ID of the HMAC key created for the GCP service account
+	AdoptedBucket          bool             `json:"adopted_bucket,omitempty"`           // the bucket existed before the install and is never deleted by zctl
+	AdoptedIamRole         bool             `json:"adopted_iam_role,omitempty"`         // the IAM role existed before the install and is never deleted by zctl
+	CreateOIDCProvider     bool             `json:"create_oidc_provider,omitempty"`     // the IAM OIDC provider of the EKS cluster is created when missing; it is shared by the cluster and never deleted by zctl
+	AWSIdentity            string           `json:"aws_identity,omitempty"`             // irsa (default) or pod-identity, see AWSIdentityIRSA
+	PodIdentityAssociation string           `json:"pod_identity_association,omitempty"` // ARN of the Pod Identity association created in pod-identity mode
+	BucketRegion           string           `json:"bucket_region,omitempty"`            // region the S3 bucket is located in, see S3BucketRegion
+	BucketOptions          *S3BucketOptions `json:"bucket_options,omitempty"`           // settings of the S3 bucket created on EKS
+	Phase                  string           `json:"phase"`                              // installing, failed or installed
+	Journal                []JournalEntry   `json:"journal,omitempty"`                  // resources created by the install, in order of creation
+	Inputs                 *SetupInputs     `json:"inputs,omitempty"`                   // options the incomplete install was started with
+	KubeContext            string           `json:"kube_context,omitempty"`             // kube context the release was installed with
+	HelmDriver             string           `json:"helm_driver,omitempty"`              // helm storage driver the release was installed with
+	VolumeClaims           *VolumeClaims    `json:"volume_claims,omitempty"`            // claims found by an uninstall --purge_data, so that a retry can delete them once the release is gone
 }
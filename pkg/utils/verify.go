@@ -0,0 +1,427 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// VerifyReport is the result of comparing the recorded setup data of an installation with the cloud resources and the Helm release.
+type VerifyReport struct {
+	Name      string       `json:"name"`
+	Namespace string       `json:"namespace"`
+	Checks    []DriftCheck `json:"checks"`
+	Drifted   bool         `json:"drifted"` // a drift remains, or a check could not be made
+}
+
+// DriftCheck is the result of one check of a VerifyReport.
+type DriftCheck struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Check string `json:"check"`
+	Drift string `json:"drift,omitempty"`
+	Fixed bool   `json:"fixed"`
+	Error string `json:"error,omitempty"`
+}
+
+// OK reports whether the check found no drift, or the drift was fixed.
+func (c DriftCheck) OK() bool {
+	return c.Error == "" && (c.Drift == "" || c.Fixed)
+}
+
+// run records a check. detect returns a description of the drift, or an empty string when there is none.
+// When fix is set and a drift is found, repair is called to remove it; a nil repair means the drift cannot be fixed by zctl.
+// It returns whether the resource is in the expected state after the check.
+func (r *VerifyReport) run(kind, name, check string, fix bool, detect func() (string, error), repair func() error) bool {
+	result := DriftCheck{Kind: kind, Name: name, Check: check}
+
+	drift, err := detect()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Drift = drift
+
+	if err == nil && drift != "" && fix {
+		if repair == nil {
+			result.Error = "cannot be fixed by zctl"
+		} else if err := repair(); err != nil {
+			result.Error = "fix failed: " + err.Error()
+		} else {
+			result.Fixed = true
+		}
+	}
+
+	r.Checks = append(r.Checks, result)
+	if !result.OK() {
+		r.Drifted = true
+	}
+
+	return result.OK()
+}
+
+// VerifyInstallation checks that the cloud resources recorded in the setup data still match what the install created:
+// the bucket exists, the IAM role trust policy and zo-s3 inline policy (EKS) or the service account, its
+// roles/storage.objectAdmin bucket binding and its HMAC key (GKE) are in place, and the Helm values still reference them.
+// When fix is set, every drift found is repaired; adopted resources are checked but never modified.
+func VerifyInstallation(setupData SetupData, fix bool) (VerifyReport, error) {
+	report := VerifyReport{
+		Name:      setupData.ReleaseName,
+		Namespace: setupData.Namespace,
+		Checks:    []DriftCheck{},
+	}
+
+	if setupData.Phase != "" && setupData.Phase != SetupPhaseInstalled {
+		return report, fmt.Errorf("the setup of release %s is %s, run 'zctl install' to resume it or 'zctl uninstall' to remove it", setupData.ReleaseName, setupData.Phase)
+	}
+
+	replacedKey := ""
+	if setupData.K8s == "eks" {
+		association := setupData.PodIdentityAssociation
+		setupData = verifyAWS(&report, setupData, fix)
+		if setupData.PodIdentityAssociation != association {
+			// The recreated association is recorded, since it is not part of the Helm values
+			if err := UpdateConfigMap(setupData); err != nil {
+				return report, err
+			}
+		}
+	} else if setupData.K8s == "gke" {
+		setupData, replacedKey = verifyGCP(&report, setupData, fix)
+	}
+
+	helmOK := verifyHelmValues(&report, setupData, fix)
+
+	// A replaced HMAC key is only removed once the Helm release uses the new one
+	if replacedKey != "" {
+		if !helmOK {
+			fmt.Printf("The replaced HMAC key %s is kept, since the Helm release does not use the new key %s yet\n", replacedKey, setupData.HMACAccessID)
+		} else if err := DeleteHMACKey(setupData.GCPProjectId, replacedKey); err != nil {
+			fmt.Println("error deleting the replaced HMAC key: ", err)
+		}
+	}
+
+	return report, nil
+}
+
+// verifyAWS checks the S3 bucket, the IAM role and, in pod-identity mode, the Pod Identity association of an EKS installation.
+// It returns the setup data with the new association when it was recreated.
+func verifyAWS(report *VerifyReport, setupData SetupData, fix bool) SetupData {
+	var issuer string
+	clusterIssuer := func() (string, error) {
+		if issuer != "" {
+			return issuer, nil
+		}
+		var err error
+		issuer, err = GetEKSOIDCIssuer(setupData.ClusterName, setupData.Region)
+		return issuer, err
+	}
+	accountId := func() string {
+		// The account is part of the recorded role ARN: arn:aws:iam::<account>:role/<name>
+		parts := strings.Split(setupData.IamRole, ":")
+		if len(parts) > 4 {
+			return parts[4]
+		}
+		return ""
+	}
+	trustPolicy := func() (string, error) {
+		if setupData.AWSIdentity == AWSIdentityPodIdentity {
+			return GetPodIdentityTrustPolicyDocument(), nil
+		}
+		issuer, err := clusterIssuer()
+		if err != nil {
+			return "", err
+		}
+		return GetIAMTrustPolicyDocument(accountId(), issuer), nil
+	}
+
+	var repairBucket func() error
+	if !setupData.AdoptedBucket {
+		repairBucket = func() error {
+			err := CreateS3Bucket(setupData.BucketName, S3BucketRegion(setupData))
+			if err != nil {
+				return err
+			}
+			// The recreated bucket gets the recorded settings, the role already has the use of its KMS key
+			options := withDefaultBucketOptions(setupData).BucketOptions
+			return ApplyS3BucketOptions(setupData.BucketName, S3BucketRegion(setupData), *options, S3BucketTags(setupData))
+		}
+	}
+	report.run(ResourceS3Bucket, setupData.BucketName, "exists", fix, func() (string, error) {
+		exists, err := S3BucketExists(setupData.BucketName, S3BucketRegion(setupData))
+		if err != nil || exists {
+			return "", err
+		}
+		return "bucket does not exist", nil
+	}, repairBucket)
+
+	// A KMS key is never recreated: the data of the bucket is encrypted with the key it had
+	if kmsKey := BucketKMSKey(setupData); kmsKey != "" {
+		report.run(ResourceKMSKey, kmsKey, "usable", fix, func() (string, error) {
+			usable, state, err := KMSKeyUsable(kmsKey, setupData.Region)
+			if err != nil || usable {
+				return "", err
+			}
+			return "key is " + state, nil
+		}, nil)
+	}
+
+	var repairRole func() error
+	if !setupData.AdoptedIamRole {
+		repairRole = func() error {
+			trust, err := trustPolicy()
+			if err != nil {
+				return err
+			}
+			roleName := setupData.IamRole[strings.LastIndex(setupData.IamRole, "/")+1:]
+			_, err = CreateIAMRoleWithTrustPolicy(trust, roleName, setupData.Region, "zo-s3", setupData.BucketName, BucketKMSKey(setupData))
+			return err
+		}
+	}
+	roleOK := report.run(ResourceIAMRole, setupData.IamRole, "exists", fix, func() (string, error) {
+		exists, err := IAMRoleExists(setupData.IamRole, setupData.Region)
+		if err != nil || exists {
+			return "", err
+		}
+		return "role does not exist", nil
+	}, repairRole)
+	if !roleOK {
+		return setupData
+	}
+
+	if setupData.AWSIdentity == AWSIdentityPodIdentity {
+		report.run(ResourcePodIdentityAssociation, setupData.PodIdentityAssociation, "exists", fix, func() (string, error) {
+			if setupData.PodIdentityAssociation == "" {
+				return "no Pod Identity association recorded", nil
+			}
+			exists, err := PodIdentityAssociationExists(setupData.PodIdentityAssociation)
+			if err != nil || exists {
+				return "", err
+			}
+			return "Pod Identity association does not exist", nil
+		}, func() error {
+			associationArn, err := CreatePodIdentityAssociation(setupData.ClusterName, setupData.Region, setupData.Namespace, PodIdentityServiceAccountName(setupData), setupData.IamRole)
+			if err != nil {
+				return err
+			}
+			setupData.PodIdentityAssociation = associationArn
+			return nil
+		})
+	}
+
+	if setupData.AdoptedIamRole {
+		// An adopted role only has to be usable by the installation; it is never modified
+		report.run(ResourceIAMRole, setupData.IamRole, "usable", fix, func() (string, error) {
+			issuer := ""
+			if setupData.AWSIdentity != AWSIdentityPodIdentity {
+				var err error
+				issuer, err = clusterIssuer()
+				if err != nil {
+					return "", err
+				}
+			}
+			if err := VerifyAdoptedIAMRole(setupData.IamRole, setupData.Region, setupData.AWSIdentity, accountId(), issuer, setupData.BucketName, BucketKMSKey(setupData)); err != nil {
+				return err.Error(), nil
+			}
+			return "", nil
+		}, nil)
+		return setupData
+	}
+
+	report.run(ResourceIAMRole, setupData.IamRole, "trust policy", fix, func() (string, error) {
+		expected, err := trustPolicy()
+		if err != nil {
+			return "", err
+		}
+		actual, err := GetIAMRoleTrustPolicy(setupData.IamRole, setupData.Region)
+		if err != nil {
+			return "", err
+		}
+		same, err := SamePolicyDocument(expected, actual)
+		if err != nil || same {
+			return "", err
+		}
+		return "trust policy differs from the one created by zctl", nil
+	}, func() error {
+		expected, err := trustPolicy()
+		if err != nil {
+			return err
+		}
+		return UpdateIAMRoleTrustPolicy(setupData.IamRole, setupData.Region, expected)
+	})
+
+	report.run(ResourceIAMRole, setupData.IamRole, "inline policy zo-s3", fix, func() (string, error) {
+		actual, found, err := GetIAMRoleInlinePolicy(setupData.IamRole, setupData.Region, "zo-s3")
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return "inline policy zo-s3 does not exist", nil
+		}
+		same, err := SamePolicyDocument(GetS3PolicyDocument(setupData.BucketName, BucketKMSKey(setupData)), actual)
+		if err != nil || same {
+			return "", err
+		}
+		return "inline policy zo-s3 differs from the one created by zctl", nil
+	}, func() error {
+		return PutIAMRoleInlinePolicy(setupData.IamRole, setupData.Region, "zo-s3", GetS3PolicyDocument(setupData.BucketName, BucketKMSKey(setupData)))
+	})
+
+	return setupData
+}
+
+// verifyGCP checks the GCS bucket, the service account, its bucket binding and its HMAC key of a GKE installation.
+// When the HMAC key is recreated, the new credentials are recorded at once, and the setup data is returned with them
+// along with the access ID of the replaced key, which is left for the caller to delete once the Helm release uses the new key.
+func verifyGCP(report *VerifyReport, setupData SetupData, fix bool) (SetupData, string) {
+	member := "serviceAccount:" + setupData.ServiceAccount
+	replacedKey := ""
+
+	report.run(ResourceGCSBucket, setupData.BucketName, "exists", fix, func() (string, error) {
+		exists, err := GCSBucketExists(setupData.BucketName)
+		if err != nil || exists {
+			return "", err
+		}
+		return "bucket does not exist", nil
+	}, func() error {
+		return CreateBucket(setupData.GCPProjectId, setupData.BucketName)
+	})
+
+	accountOK := report.run(ResourceGCPServiceAccount, setupData.ServiceAccount, "exists", fix, func() (string, error) {
+		exists, err := GCPServiceAccountExists(setupData.GCPProjectId, setupData.ServiceAccount)
+		if err != nil || exists {
+			return "", err
+		}
+		return "service account does not exist", nil
+	}, func() error {
+		_, err := CreateGCPServiceAccount(setupData.GCPProjectId, setupData.Identifier)
+		return err
+	})
+	if !accountOK {
+		return setupData, replacedKey
+	}
+
+	report.run(ResourceGCPServiceAccount, setupData.ServiceAccount, "roles/storage.objectAdmin on bucket", fix, func() (string, error) {
+		bound, err := GCSBucketHasBinding(setupData.BucketName, "roles/storage.objectAdmin", member)
+		if err != nil || bound {
+			return "", err
+		}
+		return "service account is not granted roles/storage.objectAdmin on gs://" + setupData.BucketName, nil
+	}, func() error {
+		return GrantAllAccessToBucket(setupData.GCPProjectId, setupData.BucketName, setupData.ServiceAccount)
+	})
+
+	report.run(ResourceHMACKey, setupData.HMACAccessID, "active", fix, func() (string, error) {
+		state, err := GetHMACKeyState(setupData.GCPProjectId, setupData.HMACAccessID)
+		if err != nil {
+			return "", err
+		}
+		if state == "" {
+			return "HMAC key does not exist", nil
+		}
+		if state != "ACTIVE" {
+			return "HMAC key is " + state, nil
+		}
+		return "", nil
+	}, func() error {
+		// The secret of a key cannot be read back, so a new key replaces it and the Helm values are updated with it
+		key, err := CreateHMACKey(setupData.GCPProjectId, setupData.ServiceAccount)
+		if err != nil {
+			return err
+		}
+
+		updated := setupData
+		updated.HMACAccessID = key.AccessID
+		updated.S3AccessKey = key.AccessID
+		updated.S3SecretKey = key.Secret
+		updated.Journal = append([]JournalEntry{}, setupData.Journal...)
+		for i := range updated.Journal {
+			if updated.Journal[i].Kind == ResourceHMACKey {
+				updated.Journal[i].Name = key.AccessID
+			}
+		}
+
+		// The secret of the new key is recorded before anything else, it cannot be read back either
+		if err := UpdateConfigMap(updated); err != nil {
+			if deleteErr := DeleteHMACKey(setupData.GCPProjectId, key.AccessID); deleteErr != nil {
+				return fmt.Errorf("%v; the new HMAC key %s could not be recorded nor deleted: %v", err, key.AccessID, deleteErr)
+			}
+			return err
+		}
+
+		replacedKey = setupData.HMACAccessID
+		setupData = updated
+		return nil
+	})
+
+	return setupData, replacedKey
+}
+
+// verifyHelmValues checks that the values of the deployed Helm release still reference the recorded bucket,
+// IAM role and credentials. Drift is fixed by upgrading the release with the values rebuilt from the setup data.
+// It returns whether the release uses the recorded values after the check.
+func verifyHelmValues(report *VerifyReport, setupData SetupData, fix bool) bool {
+	type expectedValue struct {
+		path   []string
+		value  string
+		secret bool
+	}
+
+	expected := []expectedValue{}
+	if setupData.K8s == "eks" && setupData.AWSIdentity == AWSIdentityPodIdentity {
+		expected = append(expected,
+			expectedValue{path: []string{"config", "ZO_S3_BUCKET_NAME"}, value: setupData.BucketName},
+			expectedValue{path: []string{"serviceAccount", "name"}, value: PodIdentityServiceAccountName(setupData)},
+		)
+	} else if setupData.K8s == "eks" {
+		expected = append(expected,
+			expectedValue{path: []string{"config", "ZO_S3_BUCKET_NAME"}, value: setupData.BucketName},
+			expectedValue{path: []string{"serviceAccount", "annotations", "eks.amazonaws.com/role-arn"}, value: setupData.IamRole},
+		)
+	} else if setupData.K8s == "gke" || (setupData.K8s == "plain" && !setupData.InstallMinIO) {
+		expected = append(expected,
+			expectedValue{path: []string{"config", "ZO_S3_BUCKET_NAME"}, value: setupData.BucketName},
+			expectedValue{path: []string{"auth", "ZO_S3_ACCESS_KEY"}, value: setupData.S3AccessKey},
+			expectedValue{path: []string{"auth", "ZO_S3_SECRET_KEY"}, value: setupData.S3SecretKey, secret: true},
+		)
+		if setupData.K8s == "plain" {
+			expected = append(expected, expectedValue{path: []string{"config", "ZO_S3_SERVER_URL"}, value: setupData.S3ServerURL})
+		}
+	}
+
+	return report.run(ResourceHelmRelease, setupData.ReleaseName, "values", fix, func() (string, error) {
+		kubeContext, err := CurrentKubeContext()
+		if err != nil {
+			return "", err
+		}
+		rel, err := GetRelease(kubeContext, setupData.ReleaseName, setupData.Namespace)
+		if err != nil {
+			return "", err
+		}
+		values, err := chartutil.CoalesceValues(rel.Chart, rel.Config)
+		if err != nil {
+			return "", err
+		}
+
+		drifts := []string{}
+		for _, e := range expected {
+			// The last key is looked up in its table, since annotation keys contain dots
+			actual := ""
+			if table, err := values.Table(strings.Join(e.path[:len(e.path)-1], ".")); err == nil && table[e.path[len(e.path)-1]] != nil {
+				actual = fmt.Sprint(table[e.path[len(e.path)-1]])
+			}
+			if actual == e.value {
+				continue
+			}
+			if e.secret {
+				drifts = append(drifts, strings.Join(e.path, ".")+" differs from the recorded value")
+			} else {
+				drifts = append(drifts, fmt.Sprintf("%s is %q instead of %q", strings.Join(e.path, "."), actual, e.value))
+			}
+		}
+
+		return strings.Join(drifts, "; "), nil
+	}, func() error {
+		_, err := Update(setupData)
+		return err
+	})
+}
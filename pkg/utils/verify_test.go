@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyReportRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		fix        bool
+		drift      string
+		detectErr  error
+		noRepair   bool
+		repairErr  error
+		wantOK     bool
+		wantFixed  bool
+		wantRepair bool
+		wantError  string
+	}{
+		{
+			name:   "no drift",
+			fix:    true,
+			wantOK: true,
+		},
+		{
+			name:  "drift without fix",
+			drift: "the bucket does not exist",
+		},
+		{
+			name:       "drift fixed",
+			fix:        true,
+			drift:      "the bucket does not exist",
+			wantOK:     true,
+			wantFixed:  true,
+			wantRepair: true,
+		},
+		{
+			name:      "drift that cannot be fixed",
+			fix:       true,
+			drift:     "the adopted role trust policy changed",
+			noRepair:  true,
+			wantError: "cannot be fixed by zctl",
+		},
+		{
+			name:       "repair failed",
+			fix:        true,
+			drift:      "the bucket does not exist",
+			repairErr:  errors.New("access denied"),
+			wantRepair: true,
+			wantError:  "fix failed: access denied",
+		},
+		{
+			name:      "check failed",
+			fix:       true,
+			detectErr: errors.New("throttled"),
+			wantError: "throttled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := VerifyReport{Name: "zo1", Namespace: "zo"}
+			repaired := false
+			repair := func() error {
+				repaired = true
+				return tt.repairErr
+			}
+			if tt.noRepair {
+				repair = nil
+			}
+
+			ok := report.run(ResourceS3Bucket, "b1", "exists", tt.fix, func() (string, error) {
+				return tt.drift, tt.detectErr
+			}, repair)
+
+			if ok != tt.wantOK || report.Drifted == tt.wantOK {
+				t.Errorf("run() = %v, Drifted = %v, want %v", ok, report.Drifted, tt.wantOK)
+			}
+			if repaired != tt.wantRepair {
+				t.Errorf("run() repaired = %v, want %v", repaired, tt.wantRepair)
+			}
+
+			check := report.Checks[0]
+			if check.Fixed != tt.wantFixed || check.Error != tt.wantError || check.Drift != tt.drift {
+				t.Errorf("run() check = %+v, want drift %q, fixed %v, error %q", check, tt.drift, tt.wantFixed, tt.wantError)
+			}
+		})
+	}
+}